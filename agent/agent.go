@@ -89,6 +89,23 @@ func init() {
 	}
 	srvLogging.Info("RPC service initialised")
 
+	// Load the RPC access control list if requested.
+	if aclFile := os.Getenv("MEEKO_RPC_ACL"); aclFile != "" {
+		acl, err := rpc.ReadACLFile(aclFile)
+		if err == nil {
+			err = srvRPC.SetAuthorizer(acl)
+		}
+		if err != nil {
+			srvLogging.Critical(err)
+			srvLogging.Close()
+			srvPubSub.Close()
+			srvRPC.Close()
+			zmq.Term()
+			panic(err)
+		}
+		srvLogging.Infof("RPC access control list loaded from %v", aclFile)
+	}
+
 	go terminateOnSignal(signalCh)
 }
 
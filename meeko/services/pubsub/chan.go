@@ -0,0 +1,148 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"errors"
+	"sync"
+)
+
+// SubscribeChan subscribes for events starting with eventKindPrefix and
+// returns a channel the events are delivered to, so that they can be processed
+// in the caller's own goroutine.
+//
+// bufferSize is the capacity of the channel and policy specifies what happens
+// when it is full. OverflowBlock blocks the whole service until there is free
+// space, OverflowDropNewest and OverflowDropOldest drop an event and report
+// *ErrEventDropped to the channel registered using Monitor. OverflowSpill is
+// not supported.
+//
+// cancel removes the listener, cancels the relevant transport subscription
+// and closes the event channel. The channel is also closed when the service
+// terminates or when the listener is removed using Unsubscribe.
+func (srv *Service) SubscribeChan(eventKindPrefix string, bufferSize int,
+	policy OverflowPolicy) (events <-chan Event, cancel func(), err error) {
+
+	if policy == OverflowSpill {
+		return nil, nil, ErrInvalidOverflowPolicy
+	}
+
+	sink := newEventSink(bufferSize, policy)
+	listener, err := srv.subscribe(eventKindPrefix, nil, nil, &SubscriptionOptions{
+		sink: sink,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The sink is closed first, a push blocked on the channel would keep
+	// the transport from processing the unsubscribe request otherwise.
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			sink.close()
+			srv.RemoveListener(listener)
+		})
+	}
+
+	go func() {
+		select {
+		case <-srv.Closed():
+			sink.close()
+		case <-sink.closedCh:
+		}
+	}()
+
+	return sink.ch, cancel, nil
+}
+
+// eventSink delivers events for a listener created by SubscribeChan.
+type eventSink struct {
+	ch        chan Event
+	policy    OverflowPolicy
+	closed    bool
+	closedCh  chan struct{}
+	closeOnce *sync.Once
+	mu        *sync.Mutex
+}
+
+func newEventSink(size int, policy OverflowPolicy) *eventSink {
+	return &eventSink{
+		ch:        make(chan Event, size),
+		policy:    policy,
+		closedCh:  make(chan struct{}),
+		closeOnce: new(sync.Once),
+		mu:        new(sync.Mutex),
+	}
+}
+
+// push sends event into the channel, applying the overflow policy.
+// It is only called from within the service loop.
+func (sink *eventSink) push(srv *Service, record *handlerRecord, event Event) {
+	// The events dropped are reported once the lock is released, so that
+	// the sink can be closed while srv.mu is being held, see unregisterPrefix.
+	dropped := sink.send(event)
+	for _, e := range dropped {
+		srv.reportDropped(record, e)
+	}
+}
+
+// send sends event into the channel and returns the events dropped.
+func (sink *eventSink) send(event Event) (dropped []Event) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.closed {
+		return nil
+	}
+
+	for {
+		select {
+		case sink.ch <- event:
+			return dropped
+		default:
+		}
+
+		switch sink.policy {
+		case OverflowBlock:
+			select {
+			case sink.ch <- event:
+			case <-sink.closedCh:
+			}
+			return nil
+
+		case OverflowDropNewest:
+			return []Event{event}
+
+		case OverflowDropOldest:
+			select {
+			case e := <-sink.ch:
+				dropped = append(dropped, e)
+			default:
+				// There is nothing buffered to be dropped.
+				return append(dropped, event)
+			}
+		}
+	}
+}
+
+// close makes push return and closes the channel. It is safe to call close
+// multiple times.
+func (sink *eventSink) close() {
+	sink.closeOnce.Do(func() {
+		// Unblock push first so that the lock can be acquired.
+		close(sink.closedCh)
+
+		sink.mu.Lock()
+		sink.closed = true
+		close(sink.ch)
+		sink.mu.Unlock()
+	})
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrInvalidOverflowPolicy = errors.New("overflow policy not supported")
@@ -0,0 +1,180 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+)
+
+// receiveSeqs reads n events from events and returns their sequence numbers.
+func receiveSeqs(t *testing.T, events <-chan pubsub.Event, n int) []int {
+	var seqs []int
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("channel closed after %v events", i)
+			}
+			seqs = append(seqs, int(event.Seq()))
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for event %v", i)
+		}
+	}
+	return seqs
+}
+
+// waitClosed waits until events is closed, draining the events buffered.
+func waitClosed(t *testing.T, events <-chan pubsub.Event) {
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for the channel to be closed")
+		}
+	}
+}
+
+func TestSubscribeChan_Overflow(t *testing.T) {
+	// The channel buffers 2 events, 5 are published.
+	cases := []struct {
+		policy   pubsub.OverflowPolicy
+		received []int
+	}{
+		{pubsub.OverflowBlock, []int{1, 2, 3, 4, 5}},
+		{pubsub.OverflowDropNewest, []int{1, 2}},
+		{pubsub.OverflowDropOldest, []int{4, 5}},
+	}
+
+	for _, c := range cases {
+		srv, _ := newTestService(t)
+		errCh := make(chan error, 10)
+		srv.Monitor(errCh)
+
+		events, cancel, err := srv.SubscribeChan("a", 2, c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if err := srv.Publish("a", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.policy != pubsub.OverflowBlock {
+			barrier(t, srv)
+		}
+
+		if seqs := receiveSeqs(t, events, len(c.received)); !equalInts(seqs, c.received) {
+			t.Errorf("policy %v: expected %v, got %v", c.policy, c.received, seqs)
+		}
+		if n := 5 - len(c.received); len(errCh) != n {
+			t.Errorf("policy %v: expected %v events dropped, got %v", c.policy, n, len(errCh))
+		}
+		for i := len(errCh); i != 0; i-- {
+			if err, ok := (<-errCh).(*pubsub.ErrEventDropped); !ok {
+				t.Errorf("policy %v: expected ErrEventDropped, got %v", c.policy, err)
+			}
+		}
+
+		cancel()
+		srv.Close()
+	}
+}
+
+func TestSubscribeChan_Spill(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	if _, _, err := srv.SubscribeChan("a", 1, pubsub.OverflowSpill); err != pubsub.ErrInvalidOverflowPolicy {
+		t.Errorf("expected ErrInvalidOverflowPolicy, got %v", err)
+	}
+}
+
+func TestSubscribeChan_Cancel(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	events, cancel, err := srv.SubscribeChan("a", 1, pubsub.OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	cancel()
+	waitClosed(t, events)
+	if got := transport.Subscriptions(); len(got) != 0 {
+		t.Errorf("expected no subscriptions, got %q", got)
+	}
+}
+
+func TestSubscribeChan_CancelBlocked(t *testing.T) {
+	// The transport is blocked while the service is blocked on the channel.
+	srv := newLoopService(t)
+	defer srv.Close()
+
+	events, cancel, err := srv.SubscribeChan("a", 1, pubsub.OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	doneCh := make(chan struct{})
+	go func() {
+		cancel()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for cancel to return")
+	}
+	waitClosed(t, events)
+}
+
+func TestSubscribeChan_Unsubscribe(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	events, cancel, err := srv.SubscribeChan("a.b", 1, pubsub.OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err := srv.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, events)
+	if got := transport.Subscriptions(); len(got) != 0 {
+		t.Errorf("expected no subscriptions, got %q", got)
+	}
+}
+
+func TestSubscribeChan_ClosedOnTermination(t *testing.T) {
+	srv, _ := newTestService(t)
+
+	events, _, err := srv.SubscribeChan("a", 1, pubsub.OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+	waitClosed(t, events)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// FallibleEventHandler is an event handler that can fail, see SubscribeFallible.
+type FallibleEventHandler func(event Event) error
+
+// RetryPolicy specifies how many times a failing handler is invoked again.
+type RetryPolicy struct {
+	// The number of retries, i.e. the handler is invoked MaxRetries+1 times
+	// at most.
+	MaxRetries int
+
+	// For how long to wait before the first retry. The delay doubles with
+	// every subsequent retry, but it never exceeds MaxBackoff unless
+	// MaxBackoff is zero.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (policy *RetryPolicy) backoff(retry int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < retry && delay > 0; i++ {
+		delay *= 2
+		if policy.MaxBackoff != 0 && delay >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff != 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+// DeadLetter is the event object published when a handler gives up on
+// an event, see SubscriptionOptions.DeadLetterPrefix.
+type DeadLetter struct {
+	// The original event. Timestamp is in nanoseconds since the Unix epoch,
+	// zero meaning not set.
+	Kind      string
+	Seq       EventSeqNum
+	Publisher string
+	Id        string
+	Timestamp int64
+	Headers   map[string]string
+
+	// The event object encoded using MessagePack, see UnmarshalBody.
+	Body []byte
+
+	// The error returned by the last handler invocation and how many times
+	// the handler was invoked in total.
+	Error    string
+	Attempts int
+}
+
+// UnmarshalBody decodes the original event object into dst.
+func (dl *DeadLetter) UnmarshalBody(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(dl.Body), dst)
+}
+
+// SubscribeFallible works like SubscribeWithOptions, but handler can fail by
+// returning an error or by panicking. The handler is then invoked again
+// according to opts.Retry. Once the retries are exhausted, *ErrHandlerFailed
+// is sent to the channel registered using Monitor and the event is published
+// as DeadLetter under opts.DeadLetterPrefix + event kind, unless the prefix
+// is empty. The events with kind starting with the prefix are dead letters
+// already, these are never dead-lettered again, so that a listener that is
+// subscribed for its own dead letters does not keep producing new ones.
+//
+// Keep in mind that the handler slot is occupied while waiting to retry,
+// see HandlerLimits. The retries are abandoned once the service starts
+// terminating.
+func (srv *Service) SubscribeFallible(eventKindPrefix string, handler FallibleEventHandler,
+	opts *SubscriptionOptions) (*Listener, error) {
+
+	return srv.subscribe(eventKindPrefix, nil, srv.retrying(handler, opts), opts)
+}
+
+// retrying turns handler into an EventHandler that retries and dead-letters
+// the events according to opts.
+func (srv *Service) retrying(handler FallibleEventHandler, opts *SubscriptionOptions) EventHandler {
+	var (
+		policy RetryPolicy
+		prefix string
+	)
+	if opts != nil {
+		if opts.Retry != nil {
+			policy = *opts.Retry
+		}
+		prefix = opts.DeadLetterPrefix
+	}
+
+	return func(event Event) {
+		var (
+			err      error
+			attempts int
+		)
+		for {
+			attempts++
+			if err = invokeFallible(handler, event); err == nil {
+				return
+			}
+			if attempts > policy.MaxRetries {
+				break
+			}
+
+			select {
+			case <-time.After(policy.backoff(attempts)):
+			case <-srv.terminatingCh:
+				return
+			}
+		}
+
+		srv.report(&ErrHandlerFailed{
+			EventKind: event.Kind(),
+			Seq:       event.Seq(),
+			Attempts:  attempts,
+			Err:       err,
+		})
+
+		if prefix != "" && !strings.HasPrefix(event.Kind(), prefix) {
+			srv.publishDeadLetter(prefix, event, err, attempts)
+		}
+	}
+}
+
+// invokeFallible calls handler, turning a panic into *ErrHandlerPanic.
+func invokeFallible(handler FallibleEventHandler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ErrHandlerPanic{r}
+		}
+	}()
+	return handler(event)
+}
+
+func (srv *Service) publishDeadLetter(prefix string, event Event, cause error, attempts int) {
+	dl := &DeadLetter{
+		Kind:      event.Kind(),
+		Seq:       event.Seq(),
+		Publisher: event.Publisher(),
+		Id:        event.Id(),
+		Headers:   event.Headers(),
+		Error:     cause.Error(),
+		Attempts:  attempts,
+	}
+	if ts := event.Timestamp(); !ts.IsZero() {
+		dl.Timestamp = ts.UnixNano()
+	}
+
+	var (
+		obj  interface{}
+		body bytes.Buffer
+	)
+	err := event.Unmarshal(&obj)
+	if err == nil {
+		err = codecs.MessagePack.Encode(&body, obj)
+	}
+	if err == nil {
+		dl.Body = body.Bytes()
+		err = srv.Publish(prefix+event.Kind(), dl)
+	}
+	if err != nil {
+		srv.report(&ErrDeadLetter{event.Kind(), event.Seq(), err})
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrHandlerPanic struct {
+	Value interface{}
+}
+
+func (err *ErrHandlerPanic) Error() string {
+	return fmt.Sprintf("Event handler panicked: %v", err.Value)
+}
+
+type ErrHandlerFailed struct {
+	EventKind string
+	Seq       EventSeqNum
+	Attempts  int
+	Err       error
+}
+
+func (err *ErrHandlerFailed) Error() string {
+	return fmt.Sprintf("Event handler failed for %v #%v after %v attempts: %v",
+		err.EventKind, err.Seq, err.Attempts, err.Err)
+}
+
+type ErrDeadLetter struct {
+	EventKind string
+	Seq       EventSeqNum
+	Err       error
+}
+
+func (err *ErrDeadLetter) Error() string {
+	return fmt.Sprintf("Failed to publish dead letter for %v #%v: %v",
+		err.EventKind, err.Seq, err.Err)
+}
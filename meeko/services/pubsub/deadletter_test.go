@@ -0,0 +1,214 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+var errHandler = errors.New("handler failed")
+
+// waitHandlerFailed waits for *ErrHandlerFailed to be sent to errCh.
+func waitHandlerFailed(t *testing.T, errCh <-chan error) *pubsub.ErrHandlerFailed {
+	select {
+	case err := <-errCh:
+		ferr, ok := err.(*pubsub.ErrHandlerFailed)
+		if !ok {
+			t.Fatalf("expected ErrHandlerFailed, got %v", err)
+		}
+		return ferr
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the handler to fail")
+	}
+	return nil
+}
+
+func TestSubscribeFallible_Retry(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	// The handler fails twice and then succeeds.
+	var attempts int32
+	_, err := srv.SubscribeFallible("a", func(event pubsub.Event) error {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return errHandler
+		}
+		return nil
+	}, &pubsub.SubscriptionOptions{
+		Retry: &pubsub.RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Publish("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubsubtest.WaitHandled(srv, 1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("expected 3 attempts, got %v", n)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
+
+func TestSubscribeFallible_DeadLetter(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	var attempts int32
+	_, err := srv.SubscribeFallible("a", func(event pubsub.Event) error {
+		atomic.AddInt32(&attempts, 1)
+		panic("boom")
+	}, &pubsub.SubscriptionOptions{
+		Retry:            &pubsub.RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond},
+		DeadLetterPrefix: "dlq.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("dlq.", dead.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := pubsub.EventHeaders{"trace": "1"}
+	if err := srv.PublishWithHeaders("a.x", map[string]int{"n": 1}, headers); err != nil {
+		t.Fatal(err)
+	}
+
+	// The panic is turned into an error.
+	ferr := waitHandlerFailed(t, errCh)
+	if ferr.EventKind != "a.x" || ferr.Seq != 1 || ferr.Attempts != 3 {
+		t.Errorf("unexpected error: %+v", ferr)
+	}
+	if perr, ok := ferr.Err.(*pubsub.ErrHandlerPanic); !ok || perr.Value != "boom" {
+		t.Errorf("expected ErrHandlerPanic, got %v", ferr.Err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("expected 3 attempts, got %v", n)
+	}
+
+	if err := dead.Wait(1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	event := dead.Events()[0]
+	if event.Kind() != "dlq.a.x" {
+		t.Errorf("expected kind dlq.a.x, got %v", event.Kind())
+	}
+
+	var dl pubsub.DeadLetter
+	if err := event.Unmarshal(&dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Kind != "a.x" || dl.Seq != 1 || dl.Publisher != "test" || dl.Attempts != 3 {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+	if dl.Id == "" || dl.Timestamp == 0 || dl.Headers["trace"] != "1" {
+		t.Errorf("expected the event properties to be kept, got %+v", dl)
+	}
+	if dl.Error != ferr.Err.Error() {
+		t.Errorf("expected error %q, got %q", ferr.Err.Error(), dl.Error)
+	}
+
+	var body map[string]int
+	if err := dl.UnmarshalBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["n"] != 1 {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
+
+func TestSubscribeFallible_OwnDeadLetters(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	// The listener is subscribed for its own dead letters.
+	_, err := srv.SubscribeFallible("", func(event pubsub.Event) error {
+		if event.Kind() == "barrier" {
+			return nil
+		}
+		return errHandler
+	}, &pubsub.SubscriptionOptions{
+		DeadLetterPrefix: "dlq.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Publish("a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the event and its dead letter fail, but only the event
+	// is dead-lettered.
+	for _, kind := range []string{"a", "dlq.a"} {
+		if ferr := waitHandlerFailed(t, errCh); ferr.EventKind != kind {
+			t.Errorf("expected %v to fail, got %v", kind, ferr.EventKind)
+		}
+	}
+	barrier(t, srv)
+
+	var kinds []string
+	for _, event := range transport.Published() {
+		if event.Kind() != "barrier" {
+			kinds = append(kinds, event.Kind())
+		}
+	}
+	if len(kinds) != 2 || kinds[1] != "dlq.a" {
+		t.Errorf("expected a single dead letter to be published, got %v", kinds)
+	}
+}
+
+func TestSubscribeFallible_CloseWhileRetrying(t *testing.T) {
+	srv, _ := newTestService(t)
+
+	calledCh := make(chan struct{}, 1)
+	_, err := srv.SubscribeFallible("a", func(event pubsub.Event) error {
+		calledCh <- struct{}{}
+		return errHandler
+	}, &pubsub.SubscriptionOptions{
+		Retry: &pubsub.RetryPolicy{MaxRetries: 1, Backoff: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Publish("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calledCh:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the handler to be called")
+	}
+
+	srv.Close()
+	select {
+	case <-srv.Closed():
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the service to terminate")
+	}
+}
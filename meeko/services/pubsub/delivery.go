@@ -0,0 +1,126 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DeliveryMode specifies the ordering guarantees for the events being
+// delivered to a listener.
+type DeliveryMode byte
+
+const (
+	// DeliveryConcurrent invokes the handler in a new goroutine for every
+	// event, so there are no ordering guarantees whatsoever. This is the default.
+	DeliveryConcurrent DeliveryMode = iota
+
+	// DeliveryOrdered invokes the handler for one event at a time, in the order
+	// the events were received.
+	DeliveryOrdered
+
+	// DeliveryOrderedPerKind invokes the handler for one event of the same kind
+	// at a time, in the order the events were received. Events of different
+	// kinds can be handled concurrently.
+	DeliveryOrderedPerKind
+)
+
+// SubscriptionOptions can be passed to SubscribeWithOptions to modify
+// the way the events are delivered to the handler.
+type SubscriptionOptions struct {
+	Delivery DeliveryMode
+
+	// Limits for the number of handlers running concurrently for the listener.
+	// These are applied together with the service-wide limits.
+	Limits *HandlerLimits
+
+	// Validate the events against the registered schemas before invoking
+	// the handler, see RegisterSchema.
+	Validate bool
+
+	// Only deliver the events matching the filter, see ParseFilter.
+	Filter *Filter
+
+	// Retry policy and the dead-letter kind prefix, only used by
+	// SubscribeFallible.
+	Retry            *RetryPolicy
+	DeadLetterPrefix string
+
+	// Set by SubscribeChan, the events are sent into the sink instead of
+	// invoking a handler.
+	sink *eventSink
+}
+
+// eventQueues keeps the events waiting to be handled for a listener using
+// one of the ordered delivery modes. There is at most one goroutine processing
+// every queue and the goroutine exits as soon as the queue is drained.
+type eventQueues struct {
+	perKind bool
+	queues  map[string][]Event
+	mu      *sync.Mutex
+}
+
+func newEventQueues(mode DeliveryMode) *eventQueues {
+	if mode == DeliveryConcurrent {
+		return nil
+	}
+	return &eventQueues{
+		perKind: mode == DeliveryOrderedPerKind,
+		queues:  make(map[string][]Event),
+		mu:      new(sync.Mutex),
+	}
+}
+
+// enqueue appends event to the relevant queue and starts a worker goroutine
+// processing the queue unless there is one running already.
+func (qs *eventQueues) enqueue(srv *Service, record *handlerRecord, event Event) {
+	var key string
+	if qs.perKind {
+		key = event.Kind()
+	}
+
+	qs.mu.Lock()
+	queue, running := qs.queues[key]
+	qs.queues[key] = append(queue, event)
+	qs.mu.Unlock()
+
+	if running {
+		return
+	}
+
+	atomic.AddInt32(&srv.numRunningHandlers, 1)
+	go func() {
+		defer func() {
+			srv.handlerReturnedCh <- record
+		}()
+		for {
+			event, ok := qs.dequeue(key)
+			if !ok {
+				return
+			}
+			srv.callHandler(record.handler, event)
+		}
+	}()
+}
+
+// dequeue pops the first event from the queue identified by key. The queue
+// is dropped once it is empty, which signals the worker to return.
+func (qs *eventQueues) dequeue(key string) (Event, bool) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	queue := qs.queues[key]
+	if len(queue) == 0 {
+		delete(qs.queues, key)
+		return nil, false
+	}
+
+	event := queue[0]
+	queue[0] = nil
+	qs.queues[key] = queue[1:]
+	return event, true
+}
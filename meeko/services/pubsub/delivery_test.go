@@ -0,0 +1,182 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+// orderChecker records the sequence numbers handled for every kind and
+// the maximum number of handlers running concurrently.
+type orderChecker struct {
+	seqs    map[string][]int
+	running int32
+	max     int32
+	mu      *sync.Mutex
+}
+
+func newOrderChecker() *orderChecker {
+	return &orderChecker{
+		seqs: make(map[string][]int),
+		mu:   new(sync.Mutex),
+	}
+}
+
+func (c *orderChecker) handler(rec *pubsubtest.Recorder) pubsub.EventHandler {
+	return func(event pubsub.Event) {
+		running := atomic.AddInt32(&c.running, 1)
+		c.mu.Lock()
+		if running > c.max {
+			c.max = running
+		}
+		c.seqs[event.Kind()] = append(c.seqs[event.Kind()], int(event.Seq()))
+		c.mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&c.running, -1)
+		rec.Handle(event)
+	}
+}
+
+func publishKinds(t *testing.T, srv *pubsub.Service, kinds []string, n int) {
+	for i := 0; i < n; i++ {
+		for _, kind := range kinds {
+			if err := srv.Publish(kind, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDelivery_Ordered(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	var (
+		rec     = pubsubtest.NewRecorder()
+		checker = newOrderChecker()
+	)
+	_, err := srv.SubscribeWithOptions("a", checker.handler(rec), &pubsub.SubscriptionOptions{
+		Delivery: pubsub.DeliveryOrdered,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishKinds(t, srv, []string{"a.x", "a.y"}, 10)
+	if err := rec.Wait(20, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// The events must be handled one at a time in the order received.
+	if checker.max != 1 {
+		t.Errorf("expected a single handler running at a time, got %v", checker.max)
+	}
+	var kinds []string
+	for _, event := range rec.Events() {
+		kinds = append(kinds, event.Kind())
+	}
+	for i, kind := range kinds {
+		if expected := []string{"a.x", "a.y"}[i%2]; kind != expected {
+			t.Fatalf("event %v: expected %v, got %v", i, expected, kind)
+		}
+	}
+	for kind, seqs := range checker.seqs {
+		if !equalInts(seqs, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+			t.Errorf("%v: unexpected order %v", kind, seqs)
+		}
+	}
+}
+
+func TestDelivery_OrderedPerKind(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	var (
+		rec     = pubsubtest.NewRecorder()
+		checker = newOrderChecker()
+		gate    = make(chan struct{})
+	)
+	handler := checker.handler(rec)
+	_, err := srv.SubscribeWithOptions("a", func(event pubsub.Event) {
+		// Block the first kind, the other one must keep going.
+		if event.Kind() == "a.x" {
+			<-gate
+		}
+		handler(event)
+	}, &pubsub.SubscriptionOptions{
+		Delivery: pubsub.DeliveryOrderedPerKind,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishKinds(t, srv, []string{"a.x", "a.y"}, 10)
+	if err := rec.Wait(10, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range rec.Events() {
+		if event.Kind() != "a.y" {
+			t.Fatalf("expected a.x to be blocked, got %v handled", event.Kind())
+		}
+	}
+
+	close(gate)
+	if err := rec.Wait(20, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"a.x", "a.y"} {
+		if seqs := checker.seqs[kind]; !equalInts(seqs, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+			t.Errorf("%v: unexpected order %v", kind, seqs)
+		}
+	}
+}
+
+func TestDelivery_WorkersStopOnClose(t *testing.T) {
+	for _, mode := range []pubsub.DeliveryMode{pubsub.DeliveryOrdered, pubsub.DeliveryOrderedPerKind} {
+		srv, _ := newTestService(t)
+
+		var (
+			rec  = pubsubtest.NewRecorder()
+			gate = make(chan struct{})
+		)
+		_, err := srv.SubscribeWithOptions("a", gatedHandler(rec, gate), &pubsub.SubscriptionOptions{
+			Delivery: mode,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publishKinds(t, srv, []string{"a.x", "a.y"}, 3)
+		barrier(t, srv)
+
+		// The service waits for the workers to drain their queues.
+		srv.Close()
+		select {
+		case <-srv.Closed():
+			t.Fatalf("mode %v: service closed while the workers were running", mode)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(gate)
+		select {
+		case <-srv.Closed():
+		case <-time.After(testTimeout):
+			t.Fatalf("mode %v: timed out waiting for the service to terminate", mode)
+		}
+		if n := rec.Count(); n != 6 {
+			t.Errorf("mode %v: expected 6 events to be handled, got %v", mode, n)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a compiled filter expression evaluated over decoded event objects.
+//
+// The expressions consist of comparisons joined by && and || and negated
+// using !, parentheses can be used for grouping. A comparison compares two
+// operands using ==, !=, <, <=, > or >=, an operand being either a literal
+// or a field path. Supported literals are strings in double quotes, numbers,
+// true, false and null. Field paths use dots to access nested fields.
+// An operand standing alone is true unless it is false, null, zero or empty.
+//
+// Example:
+//
+//	status == "failed" && (retries > 3 || job.priority == "high")
+//
+// Fields that are missing evaluate to null. Comparing values of different
+// types using <, <=, > or >= is false.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter compiles expr into a Filter.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, &ErrInvalidFilter{expr, err.Error()}
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %v", p.peek().text)
+	}
+	if err != nil {
+		return nil, &ErrInvalidFilter{expr, err.Error()}
+	}
+	return &Filter{expr, root}, nil
+}
+
+// MustParseFilter is like ParseFilter, but it panics on error.
+func MustParseFilter(expr string) *Filter {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+func (filter *Filter) String() string {
+	return filter.expr
+}
+
+// Match decodes event and evaluates the filter over the result.
+func (filter *Filter) Match(event Event) (bool, error) {
+	var obj interface{}
+	if err := event.Unmarshal(&obj); err != nil {
+		return false, err
+	}
+	return filter.match(obj), nil
+}
+
+func (filter *Filter) match(obj interface{}) bool {
+	return truthy(filter.root.eval(obj))
+}
+
+// Incoming events filtering ---------------------------------------------------
+
+// decodedEvent decodes the event object on demand, at most once.
+type decodedEvent struct {
+	event   Event
+	obj     interface{}
+	err     error
+	decoded bool
+}
+
+func (de *decodedEvent) object() (interface{}, error) {
+	if !de.decoded {
+		de.err = de.event.Unmarshal(&de.obj)
+		de.decoded = true
+	}
+	return de.obj, de.err
+}
+
+// selectRecords returns the records event is to be delivered to, applying
+// schema validation and filters. The event is decoded once at most.
+func (srv *Service) selectRecords(event Event, records []*handlerRecord) []*handlerRecord {
+	de := &decodedEvent{event: event}
+	records = srv.validateIncoming(de, records)
+
+	var filtering bool
+	for _, record := range records {
+		filtering = filtering || record.filter != nil
+	}
+	if !filtering {
+		return records
+	}
+
+	obj, err := de.object()
+	if err != nil {
+		srv.report(&ErrEventDecode{
+			EventKind: event.Kind(),
+			Seq:       event.Seq(),
+			Err:       err,
+		})
+	}
+
+	selected := make([]*handlerRecord, 0, len(records))
+	for _, record := range records {
+		if record.filter == nil || (err == nil && record.filter.match(obj)) {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// Evaluation ------------------------------------------------------------------
+
+type filterNode interface {
+	eval(obj interface{}) interface{}
+}
+
+type (
+	literalNode struct{ value interface{} }
+	fieldNode   struct{ path []string }
+	notNode     struct{ operand filterNode }
+	andNode     struct{ left, right filterNode }
+	orNode      struct{ left, right filterNode }
+	compareNode struct {
+		op          string
+		left, right filterNode
+	}
+)
+
+func (node *literalNode) eval(obj interface{}) interface{} {
+	return node.value
+}
+
+func (node *fieldNode) eval(obj interface{}) interface{} {
+	for _, name := range node.path {
+		values, ok := toMap(obj)
+		if !ok {
+			return nil
+		}
+		obj = values[name]
+	}
+	return normalizeValue(obj)
+}
+
+func (node *notNode) eval(obj interface{}) interface{} {
+	return !truthy(node.operand.eval(obj))
+}
+
+func (node *andNode) eval(obj interface{}) interface{} {
+	return truthy(node.left.eval(obj)) && truthy(node.right.eval(obj))
+}
+
+func (node *orNode) eval(obj interface{}) interface{} {
+	return truthy(node.left.eval(obj)) || truthy(node.right.eval(obj))
+}
+
+func (node *compareNode) eval(obj interface{}) interface{} {
+	left, right := node.left.eval(obj), node.right.eval(obj)
+
+	switch node.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	default:
+		return false
+	}
+
+	switch node.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// toMap returns the decoded object as a map, ok being false
+// unless all the keys are strings.
+func toMap(obj interface{}) (map[string]interface{}, bool) {
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		return obj, true
+	case map[interface{}]interface{}:
+		values := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			key, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			values[key] = v
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// normalizeValue converts all numbers to float64 and bytes to strings
+// so that the values can be compared with the literals.
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case string, bool, float64, nil:
+		return v
+	default:
+		// Maps and lists can only be tested for being set.
+		return true
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+// Parsing ---------------------------------------------------------------------
+
+type tokenKind byte
+
+const (
+	tokenOp tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, s})
+			i = end + 1
+
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := scanNumber(expr, i+1)
+			tokens = append(tokens, token{tokenNumber, expr[i:end]})
+			i = end
+
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '_' || expr[end] == '.' ||
+				unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{tokenIdent, expr[i:end]})
+			i = end
+
+		default:
+			var op string
+			for _, candidate := range filterOps {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokenOp, op})
+			i += len(op)
+		}
+	}
+
+	return tokens, nil
+}
+
+// scanNumber returns the end of the number literal continuing at i.
+func scanNumber(expr string, i int) int {
+	for ; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '.' || unicode.IsDigit(rune(c)):
+		case c == 'e' || c == 'E':
+			// The exponent can be signed.
+			if i+1 < len(expr) && (expr[i+1] == '-' || expr[i+1] == '+') {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos == len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{tokenOp, "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) acceptOp(ops ...string) (string, bool) {
+	if p.done() || p.tokens[p.pos].kind != tokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op, left, right}, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	if _, ok := p.acceptOp("("); ok {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptOp(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return node, nil
+	}
+
+	tok := p.peek()
+	switch tok.kind {
+	case tokenString:
+		p.pos++
+		return &literalNode{tok.text}, nil
+
+	case tokenNumber:
+		p.pos++
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", tok.text)
+		}
+		return &literalNode{n}, nil
+
+	case tokenIdent:
+		p.pos++
+		switch tok.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+		path := strings.Split(tok.text, ".")
+		for _, name := range path {
+			if name == "" {
+				return nil, fmt.Errorf("invalid field path %v", tok.text)
+			}
+		}
+		return &fieldNode{path}, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected %v", tok.text)
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrInvalidFilter struct {
+	Expr   string
+	Reason string
+}
+
+func (err *ErrInvalidFilter) Error() string {
+	return fmt.Sprintf("invalid filter expression %q: %v", err.Expr, err.Reason)
+}
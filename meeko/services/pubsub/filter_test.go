@@ -0,0 +1,103 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"testing"
+)
+
+func TestParseFilter_Invalid(t *testing.T) {
+	exprs := []string{
+		"",
+		"status ==",
+		"== 1",
+		"(status == 1",
+		"status == 1)",
+		"status = 1",
+		`status == "failed`,
+		"status == 1e",
+		"status == 1..2",
+		"job..priority == 1",
+		"status == 1 2",
+		"a && || b",
+		"#",
+	}
+
+	for _, expr := range exprs {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		} else if _, ok := err.(*ErrInvalidFilter); !ok {
+			t.Errorf("%q: expected ErrInvalidFilter, got %v", expr, err)
+		}
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	obj := map[interface{}]interface{}{
+		"status":  "failed",
+		"retries": int64(5),
+		"ratio":   0.00001,
+		"delta":   float32(-2.5),
+		"ok":      false,
+		"output":  []byte("log"),
+		"tags":    []interface{}{"a"},
+		"job": map[interface{}]interface{}{
+			"priority": "high",
+			"build":    uint32(12),
+		},
+	}
+
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`status == "failed"`, true},
+		{`status != "failed"`, false},
+		{`"failed" == status`, true},
+		{`status < "g"`, true},
+		{`retries > 3`, true},
+		{`retries >= 5`, true},
+		{`retries <= 4`, false},
+		{`retries == 5.0`, true},
+		{`retries == 5e0`, true},
+		{`ratio == 1e-5`, true},
+		{`ratio < 1E-4`, true},
+		{`ratio > 1e+1`, false},
+		{`delta == -2.5`, true},
+		{`delta < -.5`, true},
+		{`job.priority == "high"`, true},
+		{`job.build == 12`, true},
+		{`job.missing == null`, true},
+		{`missing == null`, true},
+		{`status.nested == null`, true},
+		{`output == "log"`, true},
+		{`retries > "3"`, false},
+		{`ok == false`, true},
+		{`!ok`, true},
+		{`ok`, false},
+		{`status`, true},
+		{`tags`, true},
+		{`missing`, false},
+		{`!!status`, true},
+		{`retries > 3 && ok`, false},
+		{`retries > 3 || ok`, true},
+		{`ok || ok && status`, false},
+		{`(ok || status) && retries`, true},
+		{`status == "failed" && (retries > 10 || job.priority == "high")`, true},
+		{`status == "failed" && !(retries > 3)`, false},
+	}
+
+	for _, c := range cases {
+		filter, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if match := filter.match(obj); match != c.match {
+			t.Errorf("%q: expected %v, got %v", c.expr, c.match, match)
+		}
+	}
+}
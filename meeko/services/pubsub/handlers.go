@@ -0,0 +1,293 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchHandler handles a batch of events, see TumblingWindow and SlidingWindow.
+type BatchHandler func(events []Event)
+
+// The handlers returned by the functions below can be passed to Subscribe and
+// the other subscription methods of srv. They use timers to deliver the events,
+// so the delivery can happen even after the listener is removed. The timers are
+// stopped once srv starts terminating, the events not delivered by then are
+// dropped. Panics are recovered from, the same way the service does it.
+
+// Debounce returns an EventHandler that invokes handler with the last event
+// of a kind once there have been no events of that kind for delay.
+// The events of different kinds are debounced independently.
+//
+// Debounce panics unless delay is positive.
+func Debounce(srv *Service, handler EventHandler, delay time.Duration) EventHandler {
+	if delay <= 0 {
+		panic("non-positive delay passed to Debounce")
+	}
+
+	var (
+		pending = make(map[string]*debounced)
+		stopped bool
+		mu      = new(sync.Mutex)
+	)
+
+	onTerminating(srv, func() {
+		mu.Lock()
+		for _, p := range pending {
+			p.timer.Stop()
+		}
+		pending = nil
+		stopped = true
+		mu.Unlock()
+	})
+
+	var fire func(kind string)
+	fire = func(kind string) {
+		mu.Lock()
+		p, ok := pending[kind]
+		if !ok {
+			// Stopped in the meantime.
+			mu.Unlock()
+			return
+		}
+		if wait := p.deadline.Sub(time.Now()); wait > 0 {
+			// More events arrived since the timer was set.
+			p.timer.Reset(wait)
+			mu.Unlock()
+			return
+		}
+		delete(pending, kind)
+		mu.Unlock()
+
+		callSafely(func() { handler(p.event) })
+	}
+
+	return func(event Event) {
+		kind := event.Kind()
+		deadline := time.Now().Add(delay)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if stopped {
+			return
+		}
+		if p, ok := pending[kind]; ok {
+			p.event = event
+			p.deadline = deadline
+			return
+		}
+		pending[kind] = &debounced{
+			event:    event,
+			deadline: deadline,
+			timer:    time.AfterFunc(delay, func() { fire(kind) }),
+		}
+	}
+}
+
+type debounced struct {
+	event    Event
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// Throttle returns an EventHandler that passes at most n events per interval
+// to handler, e.g. Throttle(handler, 10, time.Second). The events exceeding
+// the limit are dropped. Short bursts of up to n events are allowed.
+//
+// Throttle panics unless both n and interval are positive.
+func Throttle(handler EventHandler, n int, interval time.Duration) EventHandler {
+	switch {
+	case n <= 0:
+		panic("non-positive event count passed to Throttle")
+	case interval <= 0:
+		panic("non-positive interval passed to Throttle")
+	}
+
+	var (
+		rate   = float64(n) / float64(interval)
+		tokens = float64(n)
+		last   = time.Now()
+		mu     = new(sync.Mutex)
+	)
+
+	return func(event Event) {
+		mu.Lock()
+		now := time.Now()
+		tokens += float64(now.Sub(last)) * rate
+		if tokens > float64(n) {
+			tokens = float64(n)
+		}
+		last = now
+
+		if tokens < 1 {
+			mu.Unlock()
+			return
+		}
+		tokens--
+		mu.Unlock()
+
+		handler(event)
+	}
+}
+
+// TumblingWindow returns an EventHandler that collects the events and passes
+// them to handler in batches. A window is opened when an event arrives and
+// there is no window open, the batch is delivered once the window is size old.
+//
+// TumblingWindow panics unless size is positive.
+func TumblingWindow(srv *Service, handler BatchHandler, size time.Duration) EventHandler {
+	if size <= 0 {
+		panic("non-positive window size passed to TumblingWindow")
+	}
+
+	var (
+		batch   []Event
+		timer   *time.Timer
+		stopped bool
+		mu      = new(sync.Mutex)
+	)
+
+	onTerminating(srv, func() {
+		mu.Lock()
+		if timer != nil {
+			timer.Stop()
+		}
+		batch = nil
+		stopped = true
+		mu.Unlock()
+	})
+
+	flush := func() {
+		mu.Lock()
+		events := batch
+		batch = nil
+		mu.Unlock()
+
+		if events != nil {
+			callSafely(func() { handler(events) })
+		}
+	}
+
+	return func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if stopped {
+			return
+		}
+		if batch == nil {
+			timer = time.AfterFunc(size, flush)
+		}
+		batch = append(batch, event)
+	}
+}
+
+// SlidingWindow returns an EventHandler that passes the events received during
+// the last size to handler every step, as long as there are any. The events
+// can thus be delivered multiple times, in size/step consecutive batches.
+//
+// SlidingWindow panics unless 0 < step <= size.
+func SlidingWindow(srv *Service, handler BatchHandler, size, step time.Duration) EventHandler {
+	switch {
+	case step <= 0:
+		panic("non-positive step passed to SlidingWindow")
+	case step > size:
+		panic("step greater than the window size passed to SlidingWindow")
+	}
+
+	var (
+		window  []windowedEvent
+		running bool
+		timer   *time.Timer
+		stopped bool
+		mu      = new(sync.Mutex)
+	)
+
+	onTerminating(srv, func() {
+		mu.Lock()
+		if timer != nil {
+			timer.Stop()
+		}
+		window = nil
+		stopped = true
+		mu.Unlock()
+	})
+
+	var tick func()
+	tick = func() {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			return
+		}
+
+		// Drop the events that slid out of the window.
+		cutoff := time.Now().Add(-size)
+		i := 0
+		for i < len(window) && !window[i].received.After(cutoff) {
+			i++
+		}
+		window = window[i:]
+
+		if len(window) == 0 {
+			window = nil
+			running = false
+			mu.Unlock()
+			return
+		}
+
+		events := make([]Event, len(window))
+		for i, we := range window {
+			events[i] = we.event
+		}
+		mu.Unlock()
+
+		callSafely(func() { handler(events) })
+
+		mu.Lock()
+		if !stopped {
+			timer = time.AfterFunc(step, tick)
+		}
+		mu.Unlock()
+	}
+
+	return func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if stopped {
+			return
+		}
+		window = append(window, windowedEvent{event, time.Now()})
+		if !running {
+			running = true
+			timer = time.AfterFunc(step, tick)
+		}
+	}
+}
+
+type windowedEvent struct {
+	event    Event
+	received time.Time
+}
+
+// onTerminating calls stop once srv starts terminating.
+func onTerminating(srv *Service, stop func()) {
+	go func() {
+		<-srv.terminatingCh
+		stop()
+	}()
+}
+
+// callSafely calls f, recovering from any panic it may cause.
+func callSafely(f func()) {
+	defer func() {
+		recover()
+	}()
+	f()
+}
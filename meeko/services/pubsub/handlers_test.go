@@ -0,0 +1,255 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+// batchChan returns a BatchHandler sending the batches to the channel returned.
+func batchChan() (pubsub.BatchHandler, <-chan []pubsub.Event) {
+	batchCh := make(chan []pubsub.Event, 100)
+	return func(events []pubsub.Event) {
+		batchCh <- events
+	}, batchCh
+}
+
+// receiveBatch returns the next batch sent to batchCh.
+func receiveBatch(t *testing.T, batchCh <-chan []pubsub.Event) []int {
+	select {
+	case events := <-batchCh:
+		var seqs []int
+		for _, event := range events {
+			seqs = append(seqs, int(event.Seq()))
+		}
+		return seqs
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a batch")
+	}
+	return nil
+}
+
+func publishN(t *testing.T, srv *pubsub.Service, eventKind string, n int) {
+	for i := 0; i < n; i++ {
+		if err := srv.Publish(eventKind, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	rec := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("a", pubsub.Debounce(srv, rec.Handle, 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	publishN(t, srv, "a.x", 3)
+	publishN(t, srv, "a.y", 1)
+	if err := rec.Wait(2, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the last event of every kind is passed on.
+	seqs := make(map[string]pubsub.EventSeqNum)
+	for _, event := range rec.Events() {
+		seqs[event.Kind()] = event.Seq()
+	}
+	if len(seqs) != 2 || seqs["a.x"] != 3 || seqs["a.y"] != 1 {
+		t.Errorf("expected a.x 3 and a.y 1, got %v", seqs)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := rec.Count(); n != 2 {
+		t.Errorf("expected 2 events, got %v", n)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	rec := pubsubtest.NewRecorder()
+	_, err := srv.SubscribeWithOptions("a", pubsub.Throttle(rec.Handle, 2, time.Hour),
+		&pubsub.SubscriptionOptions{Delivery: pubsub.DeliveryOrdered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishN(t, srv, "a", 5)
+	if err := pubsubtest.WaitHandled(srv, 5, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// The burst allowance is used up by the first two events.
+	if seqs := recordedSeqs(rec); !equalInts(seqs, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", seqs)
+	}
+}
+
+func TestThrottle_InvalidArgs(t *testing.T) {
+	cases := []struct {
+		n        int
+		interval time.Duration
+	}{
+		{0, time.Second},
+		{-1, time.Second},
+		{10, 0},
+		{10, -time.Second},
+	}
+
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Throttle(%v, %v): expected a panic", c.n, c.interval)
+				}
+			}()
+			pubsub.Throttle(nopHandler, c.n, c.interval)
+		}()
+	}
+}
+
+func TestTumblingWindow(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	handler, batchCh := batchChan()
+	_, err := srv.SubscribeWithOptions("a", pubsub.TumblingWindow(srv, handler, 100*time.Millisecond),
+		&pubsub.SubscriptionOptions{Delivery: pubsub.DeliveryOrdered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishN(t, srv, "a", 3)
+	if seqs := receiveBatch(t, batchCh); !equalInts(seqs, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", seqs)
+	}
+
+	// The next event opens a new window.
+	publishN(t, srv, "a", 1)
+	if seqs := receiveBatch(t, batchCh); !equalInts(seqs, []int{4}) {
+		t.Errorf("expected [4], got %v", seqs)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if len(batchCh) != 0 {
+		t.Errorf("unexpected batch: %v", <-batchCh)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	handler, batchCh := batchChan()
+	_, err := srv.Subscribe("a", pubsub.SlidingWindow(srv, handler, 200*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishN(t, srv, "a", 1)
+
+	// The event is delivered every step until it slides out of the window,
+	// which makes size/step batches, give or take the timer precision.
+	if seqs := receiveBatch(t, batchCh); !equalInts(seqs, []int{1}) {
+		t.Fatalf("expected [1], got %v", seqs)
+	}
+	n := 1
+	for {
+		select {
+		case events := <-batchCh:
+			if len(events) != 1 || events[0].Seq() != 1 {
+				t.Fatalf("unexpected batch: %v", events)
+			}
+			n++
+			continue
+		case <-time.After(300 * time.Millisecond):
+		}
+		break
+	}
+	if n < 2 || n > 4 {
+		t.Errorf("expected 2 to 4 batches, got %v", n)
+	}
+}
+
+func TestHandlers_InvalidArgs(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	batch := func([]pubsub.Event) {}
+	cases := []struct {
+		name string
+		f    func()
+	}{
+		{"Debounce zero delay", func() { pubsub.Debounce(srv, nopHandler, 0) }},
+		{"TumblingWindow zero size", func() { pubsub.TumblingWindow(srv, batch, 0) }},
+		{"SlidingWindow zero step", func() { pubsub.SlidingWindow(srv, batch, time.Second, 0) }},
+		{"SlidingWindow step over size", func() { pubsub.SlidingWindow(srv, batch, time.Second, 2*time.Second) }},
+	}
+
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected a panic", c.name)
+				}
+			}()
+			c.f()
+		}()
+	}
+}
+
+func TestHandlers_StoppedOnClose(t *testing.T) {
+	const window = 100 * time.Millisecond
+
+	cases := []struct {
+		name    string
+		handler func(srv *pubsub.Service, batchCh chan<- int) pubsub.EventHandler
+	}{
+		{"Debounce", func(srv *pubsub.Service, batchCh chan<- int) pubsub.EventHandler {
+			return pubsub.Debounce(srv, func(pubsub.Event) { batchCh <- 1 }, window)
+		}},
+		{"TumblingWindow", func(srv *pubsub.Service, batchCh chan<- int) pubsub.EventHandler {
+			return pubsub.TumblingWindow(srv, func(events []pubsub.Event) { batchCh <- len(events) }, window)
+		}},
+		{"SlidingWindow", func(srv *pubsub.Service, batchCh chan<- int) pubsub.EventHandler {
+			return pubsub.SlidingWindow(srv, func(events []pubsub.Event) { batchCh <- len(events) }, window, window)
+		}},
+	}
+
+	for _, c := range cases {
+		srv, _ := newTestService(t)
+		batchCh := make(chan int, 10)
+		if _, err := srv.Subscribe("a", c.handler(srv, batchCh)); err != nil {
+			t.Fatal(err)
+		}
+
+		publishN(t, srv, "a", 1)
+		if err := pubsubtest.WaitHandled(srv, 1, testTimeout); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		select {
+		case <-srv.Closed():
+		case <-time.After(testTimeout):
+			t.Fatalf("%v: timed out waiting for the service to terminate", c.name)
+		}
+
+		time.Sleep(2 * window)
+		if len(batchCh) != 0 {
+			t.Errorf("%v: events delivered after the service was closed", c.name)
+		}
+	}
+}
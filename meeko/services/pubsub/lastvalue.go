@@ -0,0 +1,134 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// LastValue is the most recent event of a kind kept by LastValueCache.
+type LastValue struct {
+	Event    Event
+	Received time.Time
+}
+
+// LastValueCache keeps the most recent event for every event kind starting
+// with the prefix it was created for.
+//
+// All methods are thread-safe.
+type LastValueCache struct {
+	listener *Listener
+	values   map[string]*LastValue
+	watchers []chan<- *LastValue
+	mu       *sync.RWMutex
+}
+
+// NewLastValueCache subscribes for the events starting with eventKindPrefix
+// and starts caching the most recent event of every kind. An event arriving
+// late, i.e. with a lower sequence number than the cached one, is ignored,
+// unless it is so far behind that the sequence must have been restarted,
+// e.g. because the broker was restarted. The event is cached then.
+func (srv *Service) NewLastValueCache(eventKindPrefix string) (*LastValueCache, error) {
+	cache := &LastValueCache{
+		values: make(map[string]*LastValue),
+		mu:     new(sync.RWMutex),
+	}
+
+	listener, err := srv.SubscribeWithOptions(eventKindPrefix, cache.handleEvent,
+		&SubscriptionOptions{Delivery: DeliveryOrderedPerKind})
+	if err != nil {
+		return nil, err
+	}
+	cache.listener = listener
+	return cache, nil
+}
+
+// Get returns the most recent event of eventKind, if there is any.
+func (cache *LastValueCache) Get(eventKind string) (value *LastValue, ok bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	value, ok = cache.values[eventKind]
+	return
+}
+
+// Range calls f for every cached event kind until f returns false.
+// The cache is not locked while f is running, the values passed to f are
+// the ones cached when Range was called.
+func (cache *LastValueCache) Range(f func(eventKind string, value *LastValue) bool) {
+	cache.mu.RLock()
+	values := make(map[string]*LastValue, len(cache.values))
+	for kind, value := range cache.values {
+		values[kind] = value
+	}
+	cache.mu.RUnlock()
+
+	for kind, value := range values {
+		if !f(kind, value) {
+			return
+		}
+	}
+}
+
+// Len returns the number of event kinds cached.
+func (cache *LastValueCache) Len() int {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return len(cache.values)
+}
+
+// Notify registers ch for receiving the value every time a cached value is
+// replaced. The cache never blocks on ch, the notifications that do not fit
+// are dropped, so ch should be buffered.
+func (cache *LastValueCache) Notify(ch chan<- *LastValue) {
+	cache.mu.Lock()
+	cache.watchers = append(cache.watchers, ch)
+	cache.mu.Unlock()
+}
+
+// StopNotify stops sending the notifications to ch.
+func (cache *LastValueCache) StopNotify(ch chan<- *LastValue) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for i, watcher := range cache.watchers {
+		if watcher == ch {
+			cache.watchers = append(cache.watchers[:i], cache.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close removes the listener. The cached values stay available.
+func (cache *LastValueCache) Close() error {
+	return cache.listener.Close()
+}
+
+func (cache *LastValueCache) handleEvent(event Event) {
+	kind, seq := event.Kind(), event.Seq()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if current, ok := cache.values[kind]; ok {
+		// The service treats the same distance as a restart, see seqWindow.
+		d := SeqDistance(current.Event.Seq(), seq)
+		if d <= 0 && -d < seqWindowSize {
+			return
+		}
+	}
+
+	value := &LastValue{event, time.Now()}
+	cache.values[kind] = value
+
+	for _, ch := range cache.watchers {
+		select {
+		case ch <- value:
+		default:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+// injectSeqs injects events of kind a.x with the given sequence numbers
+// and waits for them to be handled.
+func injectSeqs(t *testing.T, srv *pubsub.Service, transport *pubsubtest.Transport,
+	seqs ...pubsub.EventSeqNum) {
+
+	for _, seq := range seqs {
+		if err := transport.Inject("test", "a.x", seq, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pubsubtest.WaitHandled(srv, len(seqs), testTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLastValueCache_Reordered(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	cache, err := srv.NewLastValueCache("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	ch := make(chan *pubsub.LastValue, 10)
+	cache.Notify(ch)
+
+	// #2 arrives late, the cached value is not replaced.
+	injectSeqs(t, srv, transport, 1, 3, 2)
+
+	value, ok := cache.Get("a.x")
+	if !ok {
+		t.Fatal("expected a.x to be cached")
+	}
+	if seq := value.Event.Seq(); seq != 3 {
+		t.Errorf("expected #3 to be cached, got #%v", seq)
+	}
+	if len(ch) != 2 {
+		t.Errorf("expected 2 notifications, got %v", len(ch))
+	}
+}
+
+func TestLastValueCache_Restart(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	cache, err := srv.NewLastValueCache("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	ch := make(chan *pubsub.LastValue, 10)
+	cache.Notify(ch)
+
+	// The broker restarts after #100, so the sequence numbers start over.
+	injectSeqs(t, srv, transport, 99, 100, 1)
+
+	value, ok := cache.Get("a.x")
+	if !ok {
+		t.Fatal("expected a.x to be cached")
+	}
+	if seq := value.Event.Seq(); seq != 1 {
+		t.Errorf("expected #1 to be cached, got #%v", seq)
+	}
+	if len(ch) != 3 {
+		t.Errorf("expected 3 notifications, got %v", len(ch))
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import "sync/atomic"
+
+// OverflowPolicy specifies what happens to an event that cannot be handled
+// immediately because of a handler limit and that does not fit into
+// the relevant buffer any more.
+type OverflowPolicy byte
+
+const (
+	// OverflowBlock holds the event until there is a free slot. No other
+	// events are dispatched in the meantime, they are queued in the service
+	// in the order received. The transport is never blocked, so the handlers
+	// can keep calling the service, but the queue is not bounded.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest drops the event that was just received.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest event waiting in the buffer.
+	OverflowDropOldest
+
+	// OverflowSpill appends the event to the buffer anyway, so the buffer
+	// grows beyond its size limit as necessary.
+	OverflowSpill
+)
+
+// HandlerLimits limits the number of event handlers running concurrently.
+//
+// The events held back by the limits of a listener are buffered separately
+// for every listener, so a slow listener cannot fill the service-wide buffer
+// and make the other listeners drop or block.
+//
+// The limits do not apply to the listeners using an ordered delivery mode.
+// These run a single handler at a time anyway.
+type HandlerLimits struct {
+	// The maximum number of handlers running concurrently.
+	// Zero means no limit.
+	MaxHandlers int
+
+	// The number of events that can be waiting for a free slot.
+	BufferSize int
+
+	// What to do when the buffer is full.
+	Overflow OverflowPolicy
+}
+
+func (limits *HandlerLimits) exceeded(running int) bool {
+	return limits != nil && limits.MaxHandlers > 0 && running >= limits.MaxHandlers
+}
+
+// SetHandlerLimits sets the limits for the handlers of all the listeners
+// registered with the service. nil limits mean no limits, which is the default.
+//
+// Every listener can have its own limits set as well, see SubscriptionOptions.
+func (srv *Service) SetHandlerLimits(limits *HandlerLimits) {
+	srv.mu.Lock()
+	srv.limits = limits
+	srv.mu.Unlock()
+}
+
+// Event handlers scheduling ---------------------------------------------------
+//
+// The events that cannot be handled immediately wait in one of two queues.
+// The events held back by the limits of their listener wait in the listener
+// record, the events held back by the service-wide limits wait in srv.pending.
+// The events that do not fit into a full buffer with OverflowBlock set wait
+// in srv.blocked together with all the events dispatched after them.
+//
+// All the methods below are only called from within the service loop.
+
+type pendingEvent struct {
+	record *handlerRecord
+	event  Event
+}
+
+func (srv *Service) canRun(limits *HandlerLimits, record *handlerRecord) bool {
+	return !record.limits.exceeded(record.running) && !limits.exceeded(srv.runningHandlers)
+}
+
+type blockedEvent struct {
+	limits  *HandlerLimits
+	pending *pendingEvent
+}
+
+// dispatch starts the handler for event unless a limit is exceeded, in which
+// case the event is buffered or the overflow policy is applied.
+func (srv *Service) dispatch(limits *HandlerLimits, record *handlerRecord, event Event) {
+	// Keep the order, nothing is dispatched while there are events blocked.
+	if len(srv.blocked) != 0 || !srv.tryDispatch(limits, record, event) {
+		srv.blocked = append(srv.blocked, &blockedEvent{limits, &pendingEvent{record, event}})
+	}
+}
+
+// tryDispatch works like dispatch, but it returns false instead of holding
+// the event when the buffer is full and OverflowBlock is set.
+func (srv *Service) tryDispatch(limits *HandlerLimits, record *handlerRecord, event Event) bool {
+	// Channel listeners are not using any handlers at all.
+	if record.sink != nil {
+		record.sink.push(srv, record, event)
+		return true
+	}
+
+	// Ordered delivery modes use their own worker goroutines.
+	if record.queues != nil {
+		record.queues.enqueue(srv, record, event)
+		return true
+	}
+
+	if srv.canRun(limits, record) {
+		srv.startHandler(record, event)
+		return true
+	}
+
+	var (
+		pending = &pendingEvent{record, event}
+		held    bool
+	)
+	if record.limits.exceeded(record.running) {
+		wasEmpty := len(record.pending) == 0
+		record.pending, held = srv.hold(record.pending, pending,
+			record.limits.BufferSize, record.limits.Overflow)
+		if wasEmpty && len(record.pending) != 0 {
+			srv.waitingRecords = append(srv.waitingRecords, record)
+		}
+	} else {
+		srv.pending, held = srv.hold(srv.pending, pending,
+			limits.BufferSize, limits.Overflow)
+	}
+	return held
+}
+
+// hold appends pending to queue, applying policy in case the queue is full.
+// It returns false when the event is supposed to be blocked instead.
+func (srv *Service) hold(queue []*pendingEvent, pending *pendingEvent,
+	size int, policy OverflowPolicy) ([]*pendingEvent, bool) {
+
+	if len(queue) >= size {
+		switch policy {
+		case OverflowBlock:
+			return queue, false
+
+		case OverflowDropNewest:
+			srv.reportDropped(pending.record, pending.event)
+			return queue, true
+
+		case OverflowDropOldest:
+			if len(queue) == 0 {
+				// There is nothing buffered to be dropped.
+				srv.reportDropped(pending.record, pending.event)
+				return queue, true
+			}
+			var dropped *pendingEvent
+			dropped, queue = shiftPending(queue)
+			srv.reportDropped(dropped.record, dropped.event)
+		}
+	}
+	return append(queue, pending), true
+}
+
+func (srv *Service) startHandler(record *handlerRecord, event Event) {
+	record.running++
+	srv.runningHandlers++
+	atomic.AddInt32(&srv.numRunningHandlers, 1)
+	go func() {
+		defer func() {
+			srv.handlerReturnedCh <- record
+		}()
+		srv.callHandler(record.handler, event)
+	}()
+}
+
+// handlerReturned updates the counters when a handler returns and starts
+// the events waiting in the buffers if possible.
+func (srv *Service) handlerReturned(record *handlerRecord) {
+	atomic.AddInt32(&srv.numRunningHandlers, -1)
+	if record.queues != nil {
+		return
+	}
+
+	record.running--
+	srv.runningHandlers--
+
+	srv.startBuffered()
+	srv.dispatchBlocked()
+}
+
+// startBuffered starts the handlers for the events waiting in the buffers
+// as long as the limits allow it.
+func (srv *Service) startBuffered() {
+	if len(srv.pending) == 0 && len(srv.waitingRecords) == 0 {
+		return
+	}
+
+	srv.mu.Lock()
+	limits := srv.limits
+	srv.mu.Unlock()
+
+	// The events held back by the service-wide limits go first.
+	for len(srv.pending) != 0 && !limits.exceeded(srv.runningHandlers) {
+		var pending *pendingEvent
+		pending, srv.pending = shiftPending(srv.pending)
+
+		// The listener may have reached its own limit in the meantime.
+		if rec := pending.record; rec.limits.exceeded(rec.running) {
+			if len(rec.pending) == 0 {
+				srv.waitingRecords = append(srv.waitingRecords, rec)
+			}
+			rec.pending = append([]*pendingEvent{pending}, rec.pending...)
+			continue
+		}
+		srv.startHandler(pending.record, pending.event)
+	}
+
+	// Then the events held back by the listener limits.
+	for i := 0; i < len(srv.waitingRecords); {
+		if limits.exceeded(srv.runningHandlers) {
+			return
+		}
+
+		rec := srv.waitingRecords[i]
+		for len(rec.pending) != 0 && srv.canRun(limits, rec) {
+			var pending *pendingEvent
+			pending, rec.pending = shiftPending(rec.pending)
+			srv.startHandler(rec, pending.event)
+		}
+
+		if len(rec.pending) == 0 {
+			srv.waitingRecords = append(srv.waitingRecords[:i], srv.waitingRecords[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// dispatchBlocked dispatches the events blocked by OverflowBlock, in order,
+// until an event is blocked again.
+func (srv *Service) dispatchBlocked() {
+	for len(srv.blocked) != 0 {
+		b := srv.blocked[0]
+		if !srv.tryDispatch(b.limits, b.pending.record, b.pending.event) {
+			return
+		}
+		srv.blocked[0] = nil
+		srv.blocked = srv.blocked[1:]
+	}
+}
+
+// dropPending drops all the events waiting for a free handler slot.
+func (srv *Service) dropPending() {
+	srv.blocked = nil
+	srv.pending = nil
+	for _, record := range srv.waitingRecords {
+		record.pending = nil
+	}
+	srv.waitingRecords = nil
+}
+
+func (srv *Service) reportDropped(record *handlerRecord, event Event) {
+	srv.stats.eventDropped(event)
+	srv.report(&ErrEventDropped{
+		EventKind: event.Kind(),
+		Seq:       event.Seq(),
+		Listener:  record.listener,
+	})
+}
+
+// shiftPending removes the first event from queue.
+func shiftPending(queue []*pendingEvent) (*pendingEvent, []*pendingEvent) {
+	pending := queue[0]
+	queue[0] = nil
+	return pending, queue[1:]
+}
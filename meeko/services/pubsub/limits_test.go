@@ -0,0 +1,357 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"sort"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+const testTimeout = 5 * time.Second
+
+func newTestService(t *testing.T) (*pubsub.Service, *pubsubtest.Transport) {
+	transport := pubsubtest.NewTransport("test")
+	srv, err := pubsub.NewService(transport.Factory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, transport
+}
+
+// gatedHandler returns a handler that records the events once gate is closed.
+func gatedHandler(rec *pubsubtest.Recorder, gate <-chan struct{}) pubsub.EventHandler {
+	return func(event pubsub.Event) {
+		<-gate
+		rec.Handle(event)
+	}
+}
+
+// barrier publishes an event that is only handled once all the events
+// published before have been dispatched.
+func barrier(t *testing.T, srv *pubsub.Service) {
+	rec := pubsubtest.NewRecorder()
+	listener, err := srv.Subscribe("barrier", rec.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err := srv.Publish("barrier", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Wait(1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitDropped waits until n events are dropped because of handler limits.
+func waitDropped(t *testing.T, srv *pubsub.Service, n int) {
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		var dropped uint64
+		for _, stats := range srv.Stats().Kinds {
+			dropped += stats.Dropped
+		}
+		if dropped >= uint64(n) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v events to be dropped", n)
+}
+
+func handledSeqs(rec *pubsubtest.Recorder) []int {
+	var seqs []int
+	for _, event := range rec.Events() {
+		seqs = append(seqs, int(event.Seq()))
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandlerLimits_ListenerOverflow(t *testing.T) {
+	// The listener runs a single handler and buffers 2 events, 5 are published.
+	cases := []struct {
+		policy  pubsub.OverflowPolicy
+		handled []int
+	}{
+		{pubsub.OverflowBlock, []int{1, 2, 3, 4, 5}},
+		{pubsub.OverflowDropNewest, []int{1, 2, 3}},
+		{pubsub.OverflowDropOldest, []int{1, 4, 5}},
+		{pubsub.OverflowSpill, []int{1, 2, 3, 4, 5}},
+	}
+
+	for _, c := range cases {
+		srv, _ := newTestService(t)
+		errCh := make(chan error, 10)
+		srv.Monitor(errCh)
+
+		var (
+			rec  = pubsubtest.NewRecorder()
+			gate = make(chan struct{})
+		)
+		_, err := srv.SubscribeWithOptions("a", gatedHandler(rec, gate), &pubsub.SubscriptionOptions{
+			Limits: &pubsub.HandlerLimits{MaxHandlers: 1, BufferSize: 2, Overflow: c.policy},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if err := srv.Publish("a", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.policy != pubsub.OverflowBlock {
+			barrier(t, srv)
+		}
+		close(gate)
+
+		if err := rec.Wait(len(c.handled), testTimeout); err != nil {
+			t.Fatalf("policy %v: %v", c.policy, err)
+		}
+		barrier(t, srv)
+		if seqs := handledSeqs(rec); !equalInts(seqs, c.handled) {
+			t.Errorf("policy %v: expected %v to be handled, got %v", c.policy, c.handled, seqs)
+		}
+		if n := 5 - len(c.handled); len(errCh) != n {
+			t.Errorf("policy %v: expected %v events dropped, got %v", c.policy, n, len(errCh))
+		}
+		srv.Close()
+	}
+}
+
+func TestHandlerLimits_ServiceLimit(t *testing.T) {
+	srv, _ := newTestService(t)
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+	srv.SetHandlerLimits(&pubsub.HandlerLimits{
+		MaxHandlers: 2,
+		BufferSize:  1,
+		Overflow:    pubsub.OverflowDropNewest,
+	})
+
+	var (
+		rec  = pubsubtest.NewRecorder()
+		gate = make(chan struct{})
+	)
+	if _, err := srv.Subscribe("a", gatedHandler(rec, gate)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitDropped(t, srv, 2)
+	close(gate)
+
+	if err := rec.Wait(3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := handledSeqs(rec); !equalInts(seqs, []int{1, 2, 3}) {
+		t.Errorf("expected events 1-3 to be handled, got %v", seqs)
+	}
+	if len(errCh) != 2 {
+		t.Errorf("expected 2 events dropped, got %v", len(errCh))
+	}
+	srv.Close()
+}
+
+func TestHandlerLimits_ListenerBuffersAreSeparate(t *testing.T) {
+	srv, _ := newTestService(t)
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+	srv.SetHandlerLimits(&pubsub.HandlerLimits{
+		MaxHandlers: 2,
+		BufferSize:  2,
+		Overflow:    pubsub.OverflowDropNewest,
+	})
+
+	var (
+		slow = pubsubtest.NewRecorder()
+		fast = pubsubtest.NewRecorder()
+		gate = make(chan struct{})
+	)
+	_, err := srv.SubscribeWithOptions("slow", gatedHandler(slow, gate), &pubsub.SubscriptionOptions{
+		Limits: &pubsub.HandlerLimits{MaxHandlers: 1, BufferSize: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Subscribe("fast", gatedHandler(fast, gate)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow listener fills its own buffer, which must not make
+	// the service-wide buffer overflow.
+	for i := 0; i < 5; i++ {
+		if err := srv.Publish("slow", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := srv.Publish("fast", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(gate)
+
+	if err := slow.Wait(5, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := fast.Wait(3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+	srv.Close()
+}
+
+// loopTransport forwards the events and executes the publish commands
+// in a single goroutine, the way the zmq3 transport does. Publish, Subscribe
+// and Unsubscribe thus block for as long as an event is waiting to be received
+// by the service.
+type loopTransport struct {
+	*pubsubtest.Transport
+	eventCh chan pubsub.Event
+	execCh  chan func()
+}
+
+func newLoopTransport() *loopTransport {
+	t := &loopTransport{
+		Transport: pubsubtest.NewTransport("test"),
+		eventCh:   make(chan pubsub.Event),
+		execCh:    make(chan func()),
+	}
+	go func() {
+		for {
+			select {
+			case event := <-t.Transport.EventChan():
+				select {
+				case t.eventCh <- event:
+				case <-t.Closed():
+					return
+				}
+			case f := <-t.execCh:
+				f()
+			case <-t.Closed():
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// exec runs f in the transport goroutine.
+func (t *loopTransport) exec(f func() error) error {
+	errCh := make(chan error, 1)
+	select {
+	case t.execCh <- func() { errCh <- f() }:
+		return <-errCh
+	case <-t.Closed():
+		return pubsubtest.ErrTerminated
+	}
+}
+
+func (t *loopTransport) Publish(eventKind string, eventObject interface{}, props *pubsub.EventProps) error {
+	return t.exec(func() error {
+		return t.Transport.Publish(eventKind, eventObject, props)
+	})
+}
+
+func (t *loopTransport) Subscribe(eventKindPrefix string) error {
+	return t.exec(func() error {
+		return t.Transport.Subscribe(eventKindPrefix)
+	})
+}
+
+func (t *loopTransport) Unsubscribe(eventKindPrefix string) error {
+	return t.exec(func() error {
+		return t.Transport.Unsubscribe(eventKindPrefix)
+	})
+}
+
+func (t *loopTransport) EventChan() <-chan pubsub.Event {
+	return t.eventCh
+}
+
+func newLoopService(t *testing.T) *pubsub.Service {
+	transport := newLoopTransport()
+	srv, err := pubsub.NewService(func() (pubsub.Transport, error) {
+		return transport, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestHandlerLimits_BlockedHandlerPublishes(t *testing.T) {
+	srv := newLoopService(t)
+	defer srv.Close()
+
+	var (
+		rec     = pubsubtest.NewRecorder()
+		replies = pubsubtest.NewRecorder()
+		gate    = make(chan struct{})
+	)
+	_, err := srv.SubscribeWithOptions("a", func(event pubsub.Event) {
+		<-gate
+		if err := srv.Publish("b", nil); err != nil {
+			t.Error(err)
+		}
+		rec.Handle(event)
+	}, &pubsub.SubscriptionOptions{
+		Limits: &pubsub.HandlerLimits{MaxHandlers: 1, Overflow: pubsub.OverflowBlock},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Subscribe("b", replies.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first event saturates the limit, the others are blocked.
+	for i := 0; i < 3; i++ {
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+
+	if err := rec.Wait(3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := replies.Wait(3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := handledSeqs(rec); !equalInts(seqs, []int{1, 2, 3}) {
+		t.Errorf("expected events 1-3 to be handled, got %v", seqs)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+const (
+	DefaultOutboxMaxSize       = 64 << 20
+	DefaultOutboxRetryInterval = time.Second
+)
+
+// OutboxOptions configure the outbox, see EnableOutbox.
+type OutboxOptions struct {
+	// Path of the queue file. The read position is kept in Path + ".pos".
+	Path string
+
+	// The maximum number of bytes of the events waiting to be sent.
+	// Zero means DefaultOutboxMaxSize.
+	MaxSize int64
+
+	// The events older than MaxAge are dropped instead of being sent.
+	// Zero means no expiry.
+	MaxAge time.Duration
+
+	// How long to wait before retrying a failed send.
+	// Zero means DefaultOutboxRetryInterval.
+	RetryInterval time.Duration
+}
+
+// EnableOutbox makes Publish append the events to a local file queue instead
+// of sending them directly. The events are then sent asynchronously and failed
+// sends are retried. Keep in mind that a transport failing to send an event
+// can terminate, e.g. the ZeroMQ transport does so, and the service terminates
+// with it. Events that were not sent before the service terminated are sent
+// by the next service using the same queue file.
+//
+// The queue file is synced to disk on every Publish, so the events queued
+// survive a crash of the whole machine as well.
+//
+// Publish returns ErrOutboxFull when there are too many events waiting.
+// Expired events are reported as *ErrEventExpired and failed sends as
+// *ErrOutboxSend to the channel registered using Monitor.
+func (srv *Service) EnableOutbox(opts *OutboxOptions) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.outbox != nil {
+		return ErrOutboxEnabled
+	}
+
+	ob, err := openOutbox(opts)
+	if err != nil {
+		return err
+	}
+	srv.outbox = ob
+
+	go ob.flush(srv)
+	return nil
+}
+
+// outboxRecord is the format of the records stored in the queue file.
+// Every record is prefixed with its length (uint32, BE).
+type outboxRecord struct {
+	Kind      string
+	Object    interface{}
+	Id        string
+	Timestamp int64
+	Headers   map[string]string
+
+	// Bare is set when the event is to be published without any properties.
+	// Timestamp is still set to the time the event was queued at.
+	Bare bool
+}
+
+type outbox struct {
+	opts *OutboxOptions
+
+	file    *os.File
+	posFile *os.File
+
+	// Offset of the first record not sent yet and the queue file size.
+	pos  int64
+	size int64
+
+	notifyCh chan struct{}
+	mu       *sync.Mutex
+}
+
+func openOutbox(opts *OutboxOptions) (*outbox, error) {
+	if opts.Path == "" {
+		return nil, ErrOutboxPath
+	}
+	options := *opts
+	if options.MaxSize == 0 {
+		options.MaxSize = DefaultOutboxMaxSize
+	}
+	if options.RetryInterval == 0 {
+		options.RetryInterval = DefaultOutboxRetryInterval
+	}
+
+	file, err := os.OpenFile(options.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	posFile, err := os.OpenFile(options.Path+".pos", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	ob := &outbox{
+		opts:     &options,
+		file:     file,
+		posFile:  posFile,
+		notifyCh: make(chan struct{}, 1),
+		mu:       new(sync.Mutex),
+	}
+	if err := ob.recover(); err != nil {
+		ob.close()
+		return nil, err
+	}
+	return ob, nil
+}
+
+// recover loads the read position and drops a partially written record
+// at the end of the queue file, if any.
+func (ob *outbox) recover() error {
+	info, err := ob.file.Stat()
+	if err != nil {
+		return err
+	}
+	ob.size = info.Size()
+
+	var pos int64
+	switch err := binary.Read(ob.posFile, binary.BigEndian, &pos); {
+	case err == io.EOF:
+	case err != nil:
+		return err
+	}
+	if pos > ob.size {
+		pos = ob.size
+	}
+	ob.pos = pos
+
+	// Find the end of the last complete record.
+	end := ob.pos
+	for {
+		_, next, err := ob.readAt(end)
+		if err != nil {
+			break
+		}
+		end = next
+	}
+	if end != ob.size {
+		if err := ob.file.Truncate(end); err != nil {
+			return err
+		}
+		ob.size = end
+	}
+
+	// Send the events left from the last time.
+	if ob.pos != ob.size {
+		ob.notifyCh <- struct{}{}
+	}
+	return nil
+}
+
+// append writes a new record to the end of the queue file.
+func (ob *outbox) append(eventKind string, eventObject interface{}, props *EventProps) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	rec := &outboxRecord{
+		Kind:      eventKind,
+		Object:    eventObject,
+		Timestamp: time.Now().UnixNano(),
+		Bare:      props == nil,
+	}
+	if props != nil {
+		rec.Id = props.Id
+		rec.Headers = props.Headers
+		if !props.Timestamp.IsZero() {
+			rec.Timestamp = props.Timestamp.UnixNano()
+		}
+	}
+	if err := codecs.MessagePack.Encode(&buf, rec); err != nil {
+		return err
+	}
+	record := buf.Bytes()
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.size-ob.pos+int64(len(record)) > ob.opts.MaxSize {
+		return ErrOutboxFull
+	}
+	n, err := ob.file.Write(record)
+	ob.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := ob.file.Sync(); err != nil {
+		return err
+	}
+
+	select {
+	case ob.notifyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// readAt decodes the record at offset and returns the offset of the next one.
+func (ob *outbox) readAt(offset int64) (*outboxRecord, int64, error) {
+	var header [4]byte
+	if _, err := ob.file.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[:]))
+
+	// Make sure a corrupted header does not make us allocate too much.
+	if length > ob.size-offset-4 {
+		return nil, 0, ErrOutboxCorrupted
+	}
+
+	body := make([]byte, length)
+	if _, err := ob.file.ReadAt(body, offset+4); err != nil {
+		return nil, 0, err
+	}
+
+	var record outboxRecord
+	if err := codecs.MessagePack.Decode(bytes.NewReader(body), &record); err != nil {
+		return nil, 0, err
+	}
+	return &record, offset + 4 + length, nil
+}
+
+// next returns the first record not sent yet, nil meaning there is none.
+func (ob *outbox) next() (*outboxRecord, int64, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.pos == ob.size {
+		return nil, 0, nil
+	}
+	return ob.readAt(ob.pos)
+}
+
+// advance marks the records before next as sent. The queue file is truncated
+// once all the records are sent so that it does not grow forever.
+func (ob *outbox) advance(next int64) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.pos = next
+	if ob.pos == ob.size {
+		if err := ob.file.Truncate(0); err != nil {
+			return err
+		}
+		ob.pos = 0
+		ob.size = 0
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(ob.pos))
+	if _, err := ob.posFile.WriteAt(buf[:], 0); err != nil {
+		return err
+	}
+	return ob.posFile.Sync()
+}
+
+// flush sends the queued events until the service terminates.
+func (ob *outbox) flush(srv *Service) {
+	defer ob.close()
+
+	for {
+		record, next, err := ob.next()
+		if err != nil {
+			srv.abort(err)
+			return
+		}
+
+		// Wait for more events to be appended.
+		if record == nil {
+			select {
+			case <-ob.notifyCh:
+				continue
+			case <-srv.Closed():
+				return
+			}
+		}
+
+		timestamp := time.Unix(0, record.Timestamp)
+		if ob.opts.MaxAge != 0 && time.Since(timestamp) > ob.opts.MaxAge {
+			srv.report(&ErrEventExpired{record.Kind, record.Id})
+		} else {
+			var props *EventProps
+			if !record.Bare {
+				props = &EventProps{
+					Id:        record.Id,
+					Timestamp: timestamp,
+					Headers:   EventHeaders(record.Headers),
+				}
+			}
+			if err := srv.sendEvent(record.Kind, record.Object, props); err != nil {
+				srv.report(&ErrOutboxSend{record.Kind, err})
+				select {
+				case <-time.After(ob.opts.RetryInterval):
+					continue
+				case <-srv.Closed():
+					return
+				}
+			}
+		}
+
+		if err := ob.advance(next); err != nil {
+			srv.abort(err)
+			return
+		}
+	}
+}
+
+func (ob *outbox) close() {
+	ob.mu.Lock()
+	ob.file.Close()
+	ob.posFile.Close()
+	ob.mu.Unlock()
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrOutboxEnabled   = errors.New("outbox already enabled")
+	ErrOutboxPath      = errors.New("outbox path not set")
+	ErrOutboxFull      = errors.New("outbox full")
+	ErrOutboxCorrupted = errors.New("outbox record exceeds the queue file")
+)
+
+type ErrEventExpired struct {
+	EventKind string
+	Id        string
+}
+
+func (err *ErrEventExpired) Error() string {
+	return fmt.Sprintf("Event %v of kind %v expired in the outbox", err.Id, err.EventKind)
+}
+
+type ErrOutboxSend struct {
+	EventKind string
+	Err       error
+}
+
+func (err *ErrOutboxSend) Error() string {
+	return fmt.Sprintf("Failed to send %v from the outbox: %v", err.EventKind, err.Err)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+// queueEvents leaves n events in the outbox at path by publishing them while
+// the transport is not able to send them.
+func queueEvents(t *testing.T, path string, n int) {
+	srv, transport := newTestService(t)
+	errCh := make(chan error, n+10)
+	srv.Monitor(errCh)
+	transport.Close()
+
+	err := srv.EnableOutbox(&pubsub.OutboxOptions{
+		Path:          path,
+		RetryInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		if _, ok := err.(*pubsub.ErrOutboxSend); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the send to fail")
+	}
+	srv.Close()
+	srv.Wait()
+}
+
+func appendBytes(t *testing.T, path string, p []byte) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutbox_Recover(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+		sent    int
+	}{
+		{
+			"clean shutdown",
+			func(*testing.T, string) {},
+			3,
+		},
+		{
+			"partial record",
+			func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0, 100, 1, 2, 3})
+			},
+			3,
+		},
+		{
+			"corrupted length header",
+			func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+			},
+			3,
+		},
+		{
+			"read position past the end",
+			func(t *testing.T, path string) {
+				var pos [8]byte
+				binary.BigEndian.PutUint64(pos[:], 1<<40)
+				if err := os.WriteFile(path+".pos", pos[:], 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			0,
+		},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "outbox")
+		queueEvents(t, path, 3)
+		c.corrupt(t, path)
+
+		srv, _ := newTestService(t)
+		errCh := make(chan error, 10)
+		srv.Monitor(errCh)
+
+		rec := pubsubtest.NewRecorder()
+		if _, err := srv.Subscribe("a", rec.Handle); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.EnableOutbox(&pubsub.OutboxOptions{Path: path}); err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+
+		// The barrier goes through the outbox as well, after the events left.
+		barrier(t, srv)
+		if err := rec.Wait(c.sent, testTimeout); err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if n := len(rec.Events()); n != c.sent {
+			t.Errorf("%v: expected %v events sent, got %v", c.name, c.sent, n)
+		}
+		if len(errCh) != 0 {
+			t.Errorf("%v: unexpected error: %v", c.name, <-errCh)
+		}
+		srv.Close()
+	}
+}
+
+func TestOutbox_Full(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	transport.Close()
+
+	err := srv.EnableOutbox(&pubsub.OutboxOptions{
+		Path:    filepath.Join(t.TempDir(), "outbox"),
+		MaxSize: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var full bool
+	for i := 0; i < 100 && !full; i++ {
+		switch err := srv.Publish("a", i); err {
+		case nil:
+		case pubsub.ErrOutboxFull:
+			full = true
+		default:
+			t.Fatal(err)
+		}
+	}
+	if !full {
+		t.Error("expected ErrOutboxFull")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SubscribePattern registers a handler for events with kind matching pattern.
+//
+// The pattern consists of segments separated by dots. A segment can be either
+// a literal string, which must match the relevant event kind segment exactly,
+// or * which matches any single segment, or ** which matches one or more
+// segments. ** can only be used as the last segment. For example, jobs.*.failed
+// matches jobs.build.failed and deploy.** matches deploy.web.started.
+//
+// The service subscribes for the longest literal prefix of pattern and filters
+// the rest on the client side. The listener returned can be removed using
+// RemoveListener just like any other listener. nil opts means the defaults.
+func (srv *Service) SubscribePattern(pattern string, handler EventHandler,
+	opts *SubscriptionOptions) (*Listener, error) {
+
+	p, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.subscribe(p.prefix, p, handler, opts)
+}
+
+const (
+	wildcardSegment     = "*"
+	wildcardTailSegment = "**"
+)
+
+// kindPattern represents a parsed event kind pattern.
+type kindPattern struct {
+	prefix   string
+	segments []string
+}
+
+func parsePattern(pattern string) (*kindPattern, error) {
+	segments := strings.Split(pattern, ".")
+	prefix := pattern
+
+	for i, segment := range segments {
+		switch {
+		case segment == wildcardTailSegment && i != len(segments)-1:
+			return nil, &ErrInvalidPattern{pattern, "** must be the last segment"}
+		case segment != wildcardSegment && segment != wildcardTailSegment &&
+			strings.Contains(segment, "*"):
+			return nil, &ErrInvalidPattern{pattern, "* must form a whole segment"}
+		case strings.Contains(segment, "*") && prefix == pattern:
+			prefix = strings.Join(segments[:i], ".")
+			if i != 0 {
+				prefix += "."
+			}
+		}
+	}
+
+	return &kindPattern{prefix, segments}, nil
+}
+
+// match returns true if kind matches the pattern.
+func (p *kindPattern) match(kind string) bool {
+	parts := strings.Split(kind, ".")
+
+	for i, segment := range p.segments {
+		switch {
+		case segment == wildcardTailSegment:
+			return i < len(parts)
+		case i >= len(parts):
+			return false
+		case segment == wildcardSegment:
+			if parts[i] == "" {
+				return false
+			}
+		case segment != parts[i]:
+			return false
+		}
+	}
+
+	return len(parts) == len(p.segments)
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrInvalidPattern struct {
+	Pattern string
+	Reason  string
+}
+
+func (err *ErrInvalidPattern) Error() string {
+	return fmt.Sprintf("invalid event kind pattern %q: %v", err.Pattern, err.Reason)
+}
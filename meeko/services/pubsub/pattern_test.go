@@ -0,0 +1,123 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+func TestSubscribePattern_Match(t *testing.T) {
+	cases := []struct {
+		pattern string
+		kind    string
+		match   bool
+	}{
+		{"jobs.*.failed", "jobs.build.failed", true},
+		{"jobs.*.failed", "jobs.build.started", false},
+		{"jobs.*.failed", "jobs.build.web.failed", false},
+		{"jobs.*.failed", "jobs..failed", false},
+		{"deploy.**", "deploy.web", true},
+		{"deploy.**", "deploy.web.started", true},
+		{"deploy.**", "deploy", false},
+		{"deploy.**", "deployment.web", false},
+		{"*.started", "web.started", true},
+		{"*.started", "web.stopped", false},
+		{"**", "anything.at.all", true},
+		{"jobs.build", "jobs.build", true},
+		{"jobs.build", "jobs.builder", false},
+	}
+
+	for _, c := range cases {
+		srv, _ := newTestService(t)
+
+		rec := pubsubtest.NewRecorder()
+		if _, err := srv.SubscribePattern(c.pattern, rec.Handle, nil); err != nil {
+			t.Fatalf("pattern %q: %v", c.pattern, err)
+		}
+		if err := srv.Publish(c.kind, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// In case the event is not matched, it has been dropped once
+		// the events published after it are dispatched.
+		if c.match {
+			if err := rec.Wait(1, testTimeout); err != nil {
+				t.Fatalf("pattern %q, kind %q: %v", c.pattern, c.kind, err)
+			}
+		} else {
+			barrier(t, srv)
+		}
+
+		var matched bool
+		for _, event := range rec.Events() {
+			if event.Kind() == c.kind {
+				matched = true
+			}
+		}
+		if matched != c.match {
+			t.Errorf("pattern %q, kind %q: expected match %v, got %v",
+				c.pattern, c.kind, c.match, matched)
+		}
+		srv.Close()
+	}
+}
+
+func TestSubscribePattern_Prefix(t *testing.T) {
+	cases := []struct {
+		pattern string
+		prefix  string
+	}{
+		{"jobs.*.failed", "jobs."},
+		{"deploy.**", "deploy."},
+		{"*.started", ""},
+		{"jobs.build", "jobs.build"},
+	}
+
+	for _, c := range cases {
+		srv, transport := newTestService(t)
+
+		if _, err := srv.SubscribePattern(c.pattern, func(pubsub.Event) {}, nil); err != nil {
+			t.Fatalf("pattern %q: %v", c.pattern, err)
+		}
+		barrier(t, srv)
+
+		var subscribed bool
+		for _, prefix := range transport.Subscriptions() {
+			if prefix == c.prefix {
+				subscribed = true
+			}
+		}
+		if !subscribed {
+			t.Errorf("pattern %q: expected %q to be subscribed, got %q",
+				c.pattern, c.prefix, transport.Subscriptions())
+		}
+		srv.Close()
+	}
+}
+
+func TestSubscribePattern_Invalid(t *testing.T) {
+	patterns := []string{
+		"deploy.**.started",
+		"jobs.build*",
+		"jobs.*x.failed",
+		"***",
+	}
+
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	for _, pattern := range patterns {
+		_, err := srv.SubscribePattern(pattern, func(pubsub.Event) {}, nil)
+		if _, ok := err.(*pubsub.ErrInvalidPattern); !ok {
+			t.Errorf("pattern %q: expected ErrInvalidPattern, got %v", pattern, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// PublishObserver is called for the events published by the service once
+// their sequence numbers are known, see OnPublished.
+type PublishObserver func(event *PublishedEvent)
+
+type publishObserver struct {
+	kindPrefix string
+	observer   PublishObserver
+}
+
+// OnPublished registers observer to be called for every event starting with
+// eventKindPrefix published by the service, as soon as the sequence number
+// assigned to the event by the broker is known. This makes it possible to keep
+// the events on the publisher side, before they reach any lossy link.
+//
+// The events are published using AckingTransport.PublishAcked while there is
+// an observer registered for them, so the transport must implement that
+// interface, otherwise ErrAcksNotSupported is returned. The acknowledgements
+// can get lost, so observer is not guaranteed to be called for every event.
+//
+// observer is called from within the service loop, so it must not block.
+// cancel unregisters observer.
+func (srv *Service) OnPublished(eventKindPrefix string,
+	observer PublishObserver) (cancel func(), err error) {
+
+	if _, ok := srv.transport.(AckingTransport); !ok {
+		return nil, ErrAcksNotSupported
+	}
+
+	record := &publishObserver{eventKindPrefix, observer}
+
+	srv.mu.Lock()
+	srv.observers = append(srv.observers, record)
+	srv.mu.Unlock()
+
+	return func() {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		for i, r := range srv.observers {
+			if r == record {
+				srv.observers = append(srv.observers[:i], srv.observers[i+1:]...)
+				return
+			}
+		}
+	}, nil
+}
+
+// sendEvent publishes the event using the transport, asking for
+// an acknowledgement in case there is an observer interested in it.
+func (srv *Service) sendEvent(eventKind string, eventObject interface{}, props *EventProps) error {
+	if t, ok := srv.transport.(AckingTransport); ok && srv.observed(eventKind) {
+		return t.PublishAcked(eventKind, eventObject, props)
+	}
+	return srv.transport.Publish(eventKind, eventObject, props)
+}
+
+func (srv *Service) observed(eventKind string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, record := range srv.observers {
+		if strings.HasPrefix(eventKind, record.kindPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyObservers passes event to the matching observers.
+// It is only called from within the service loop.
+func (srv *Service) notifyObservers(event *PublishedEvent) {
+	srv.mu.Lock()
+	var observers []PublishObserver
+	for _, record := range srv.observers {
+		if strings.HasPrefix(event.Kind, record.kindPrefix) {
+			observers = append(observers, record.observer)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrAcksNotSupported = errors.New("transport cannot acknowledge published events")
@@ -0,0 +1,76 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsubtest
+
+import (
+	// Stdlib
+	"bytes"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// Event implements pubsub.Event. It is created by Transport for every event
+// published or injected.
+type Event struct {
+	kind      string
+	seq       pubsub.EventSeqNum
+	publisher string
+	props     pubsub.EventProps
+	body      []byte
+}
+
+func newEvent(publisher, eventKind string, seq pubsub.EventSeqNum,
+	eventObject interface{}, props *pubsub.EventProps) (*Event, error) {
+
+	var buf bytes.Buffer
+	if err := codecs.MessagePack.Encode(&buf, eventObject); err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		kind:      eventKind,
+		seq:       seq,
+		publisher: publisher,
+		body:      buf.Bytes(),
+	}
+	if props != nil {
+		event.props = *props
+	}
+	return event, nil
+}
+
+// pubsub.Event interface ------------------------------------------------------
+
+func (event *Event) Kind() string {
+	return event.kind
+}
+
+func (event *Event) Seq() pubsub.EventSeqNum {
+	return event.seq
+}
+
+func (event *Event) Publisher() string {
+	return event.publisher
+}
+
+func (event *Event) Id() string {
+	return event.props.Id
+}
+
+func (event *Event) Timestamp() time.Time {
+	return event.props.Timestamp
+}
+
+func (event *Event) Headers() pubsub.EventHeaders {
+	return event.props.Headers
+}
+
+func (event *Event) Unmarshal(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(event.body), dst)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsubtest
+
+import (
+	// Stdlib
+	"sync"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+)
+
+// Recorder records the events it handles. Its Handle method can be passed
+// to Service.Subscribe directly.
+type Recorder struct {
+	events   []pubsub.Event
+	notifyCh chan struct{}
+	mu       *sync.Mutex
+}
+
+// NewRecorder creates a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		notifyCh: make(chan struct{}),
+		mu:       new(sync.Mutex),
+	}
+}
+
+// Handle records event.
+func (rec *Recorder) Handle(event pubsub.Event) {
+	rec.mu.Lock()
+	rec.events = append(rec.events, event)
+	close(rec.notifyCh)
+	rec.notifyCh = make(chan struct{})
+	rec.mu.Unlock()
+}
+
+// Events returns the events recorded so far.
+func (rec *Recorder) Events() []pubsub.Event {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]pubsub.Event(nil), rec.events...)
+}
+
+// Count returns the number of events recorded so far.
+func (rec *Recorder) Count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.events)
+}
+
+// Wait blocks until at least n events are recorded. It returns ErrTimeout
+// when that does not happen within timeout.
+func (rec *Recorder) Wait(n int, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		rec.mu.Lock()
+		count, notifyCh := len(rec.events), rec.notifyCh
+		rec.mu.Unlock()
+
+		if count >= n {
+			return nil
+		}
+
+		select {
+		case <-notifyCh:
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+}
+
+// waitPollInterval is how often WaitHandled checks the service statistics.
+const waitPollInterval = 5 * time.Millisecond
+
+// WaitHandled blocks until the handlers registered with srv return for
+// at least n events in total, counting every handler invocation. It returns
+// ErrTimeout when that does not happen within timeout.
+//
+// The events delivered using Service.SubscribeChan are not counted.
+func WaitHandled(srv *pubsub.Service, n int, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		var handled uint64
+		for _, stats := range srv.Stats().Kinds {
+			handled += stats.HandlerCalls
+		}
+		if handled >= uint64(n) {
+			return nil
+		}
+
+		select {
+		case <-time.After(waitPollInterval):
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package pubsubtest provides an in-memory pubsub.Transport implementation
+// that can be used for unit testing code built on top of pubsub.Service
+// without any broker running.
+//
+// Transport behaves like the broker does. It assigns per-kind sequence
+// numbers to the events published, delivers the events to the prefixes
+// subscribed for and sends the event sequence table on every subscription.
+// On top of that it can inject gaps, duplicates, reordering and transport
+// errors, see DropNext, DuplicateNext, ReorderNext and Fail.
+//
+// Recorder and WaitHandled can be used to wait for the events to be handled.
+package pubsubtest
+
+import (
+	// Stdlib
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services"
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+)
+
+// Transport implements pubsub.Transport in memory. The events published are
+// delivered back to the Service using the Transport as long as it is
+// subscribed for them.
+//
+// All methods are thread-safe.
+type Transport struct {
+	identity string
+
+	// Broker state and recorded events.
+	subscriptions map[string]int
+	seqs          map[string]pubsub.EventSeqNum
+	seqNum64      bool
+	faults        map[string]*fault
+	droppedTables int
+	published     []*Event
+
+	// Events, tables and acknowledgements waiting to be received
+	// by the Service, in order.
+	queue  []interface{}
+	wakeCh chan struct{}
+	mu     *sync.Mutex
+
+	// Output interface for the Service using this Transport.
+	eventCh chan pubsub.Event
+	tableCh chan pubsub.EventSeqTable
+	ackCh   chan *pubsub.PublishedEvent
+	errorCh chan error
+
+	// Termination management
+	closedCh chan struct{}
+	err      error
+}
+
+// fault holds the faults to be injected for an event kind.
+type fault struct {
+	drop      int
+	duplicate int
+	reorder   int
+	held      []*Event
+}
+
+// NewTransport creates a new Transport. identity is used as the publisher
+// of the events published through the Transport.
+func NewTransport(identity string) *Transport {
+	t := &Transport{
+		identity:      identity,
+		subscriptions: make(map[string]int),
+		seqs:          make(map[string]pubsub.EventSeqNum),
+		faults:        make(map[string]*fault),
+		wakeCh:        make(chan struct{}, 1),
+		mu:            new(sync.Mutex),
+		eventCh:       make(chan pubsub.Event),
+		tableCh:       make(chan pubsub.EventSeqTable),
+		ackCh:         make(chan *pubsub.PublishedEvent),
+		errorCh:       make(chan error, 1),
+		closedCh:      make(chan struct{}),
+	}
+
+	go t.pump()
+	return t
+}
+
+// Factory returns a function that can be passed to pubsub.NewService.
+func (t *Transport) Factory() func() (pubsub.Transport, error) {
+	return func() (pubsub.Transport, error) {
+		return t, nil
+	}
+}
+
+// Recorded events and broker state --------------------------------------------
+
+// Published returns all the events published so far, including the ones
+// that were not delivered.
+func (t *Transport) Published() []*Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Event(nil), t.published...)
+}
+
+// Subscriptions returns the kind prefixes currently subscribed for, sorted.
+func (t *Transport) Subscriptions() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefixes := make([]string, 0, len(t.subscriptions))
+	for prefix := range t.subscriptions {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// Seq returns the sequence number of the last event published as eventKind.
+func (t *Transport) Seq(eventKind string) pubsub.EventSeqNum {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seqs[eventKind]
+}
+
+// SetSeq sets the sequence number of the last event published as eventKind,
+// e.g. to test what happens when the sequence numbers wrap around.
+func (t *Transport) SetSeq(eventKind string, seq pubsub.EventSeqNum) {
+	t.mu.Lock()
+	t.seqs[eventKind] = seq
+	t.mu.Unlock()
+}
+
+// SetSeqNum64 makes the Transport assign 64-bit sequence numbers. By default
+// the sequence numbers are 32-bit and wrap around after math.MaxUint32,
+// the same way the broker does unless PubSubSeqNum64 is set.
+func (t *Transport) SetSeqNum64(enabled bool) {
+	t.mu.Lock()
+	t.seqNum64 = enabled
+	t.mu.Unlock()
+}
+
+// Fault injection -------------------------------------------------------------
+
+// DropNext makes the Transport drop the next n events published as eventKind.
+// The events are assigned sequence numbers, so the Service detects a gap.
+func (t *Transport) DropNext(eventKind string, n int) {
+	t.mu.Lock()
+	t.fault(eventKind).drop += n
+	t.mu.Unlock()
+}
+
+// DuplicateNext makes the Transport deliver the next n events published
+// as eventKind twice.
+func (t *Transport) DuplicateNext(eventKind string, n int) {
+	t.mu.Lock()
+	t.fault(eventKind).duplicate += n
+	t.mu.Unlock()
+}
+
+// ReorderNext makes the Transport hold the next n events published
+// as eventKind and deliver them in the reverse order once all of them are
+// published.
+func (t *Transport) ReorderNext(eventKind string, n int) {
+	t.mu.Lock()
+	t.fault(eventKind).reorder += n
+	t.mu.Unlock()
+}
+
+// DropNextSeqTables makes the Transport not send the event sequence tables
+// requested by the next n subscriptions.
+func (t *Transport) DropNextSeqTables(n int) {
+	t.mu.Lock()
+	t.droppedTables += n
+	t.mu.Unlock()
+}
+
+// Inject delivers an event with the given sequence number to the Service
+// using this Transport, bypassing the sequence numbers kept by the Transport.
+// The event is only delivered when the Transport is subscribed for it.
+func (t *Transport) Inject(publisher, eventKind string, seq pubsub.EventSeqNum,
+	eventObject interface{}, props *pubsub.EventProps) error {
+
+	event, err := newEvent(publisher, eventKind, seq, eventObject, props)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.subscribed(eventKind) {
+		t.enqueue(event)
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// InjectSeqTable sends table to the Service using this Transport as if it was
+// sent by the broker. Keep in mind that the Service takes the table for
+// the reply to the oldest subscription still waiting for one with the prefix
+// matching all the kinds in the table, if there is any.
+func (t *Transport) InjectSeqTable(table pubsub.EventSeqTable) {
+	t.mu.Lock()
+	t.enqueue(table)
+	t.mu.Unlock()
+}
+
+// Fail emits err as an internal transport error, which makes the Service
+// using this Transport terminate.
+func (t *Transport) Fail(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+
+	select {
+	case t.errorCh <- err:
+	default:
+	}
+}
+
+// pubsub.Transport interface --------------------------------------------------
+
+func (t *Transport) Publish(eventKind string, eventObject interface{}, props *pubsub.EventProps) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.publish(eventKind, eventObject, props)
+	return err
+}
+
+func (t *Transport) Subscribe(eventKindPrefix string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.subscriptions[eventKindPrefix]++
+
+	if t.droppedTables != 0 {
+		t.droppedTables--
+		return nil
+	}
+
+	// Send the event sequence table for the prefix the same way the broker does.
+	table := make(pubsub.EventSeqTable)
+	for kind, seq := range t.seqs {
+		if strings.HasPrefix(kind, eventKindPrefix) {
+			table[kind] = seq
+		}
+	}
+	t.enqueue(table)
+	return nil
+}
+
+func (t *Transport) Unsubscribe(eventKindPrefix string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count, ok := t.subscriptions[eventKindPrefix]
+	if !ok {
+		return &ErrNotSubscribed{eventKindPrefix}
+	}
+	if count == 1 {
+		delete(t.subscriptions, eventKindPrefix)
+	} else {
+		t.subscriptions[eventKindPrefix] = count - 1
+	}
+	return nil
+}
+
+func (t *Transport) EventChan() <-chan pubsub.Event {
+	return t.eventCh
+}
+
+func (t *Transport) EventSeqTableChan() <-chan pubsub.EventSeqTable {
+	return t.tableCh
+}
+
+func (t *Transport) ErrorChan() <-chan error {
+	return t.errorCh
+}
+
+// pubsub.AckingTransport interface --------------------------------------------
+
+// PublishAcked works like Publish, the event is acknowledged right after it
+// is delivered, unless the delivery is delayed by the faults injected.
+func (t *Transport) PublishAcked(eventKind string, eventObject interface{}, props *pubsub.EventProps) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	event, err := t.publish(eventKind, eventObject, props)
+	if err != nil {
+		return err
+	}
+	t.enqueue(&pubsub.PublishedEvent{
+		Kind:      event.kind,
+		Seq:       event.seq,
+		Publisher: event.publisher,
+		Props:     props,
+		Body:      event.body,
+	})
+	return nil
+}
+
+func (t *Transport) AckChan() <-chan *pubsub.PublishedEvent {
+	return t.ackCh
+}
+
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.closedCh:
+	default:
+		close(t.closedCh)
+	}
+	return nil
+}
+
+func (t *Transport) Closed() <-chan struct{} {
+	return t.closedCh
+}
+
+func (t *Transport) Wait() error {
+	<-t.Closed()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Private methods -------------------------------------------------------------
+
+// publish assigns the next sequence number to the event and delivers it,
+// applying the faults injected. t.mu must be locked.
+func (t *Transport) publish(eventKind string, eventObject interface{},
+	props *pubsub.EventProps) (*Event, error) {
+
+	select {
+	case <-t.closedCh:
+		return nil, ErrTerminated
+	default:
+	}
+
+	seq := t.seqs[eventKind] + 1
+	if !t.seqNum64 {
+		// Let the 32-bit sequence numbers wrap around.
+		seq = pubsub.EventSeqNum(uint32(seq))
+	}
+	event, err := newEvent(t.identity, eventKind, seq, eventObject, props)
+	if err != nil {
+		return nil, err
+	}
+	t.seqs[eventKind] = seq
+	t.published = append(t.published, event)
+
+	if !t.subscribed(eventKind) {
+		return event, nil
+	}
+
+	f, ok := t.faults[eventKind]
+	if !ok {
+		t.enqueue(event)
+		return event, nil
+	}
+
+	switch {
+	case f.drop != 0:
+		f.drop--
+	case f.duplicate != 0:
+		f.duplicate--
+		t.enqueue(event, event)
+	case f.reorder != 0:
+		f.reorder--
+		f.held = append(f.held, event)
+		if f.reorder == 0 {
+			for i := len(f.held) - 1; i >= 0; i-- {
+				t.enqueue(f.held[i])
+			}
+			f.held = nil
+		}
+	default:
+		t.enqueue(event)
+	}
+	if f.drop == 0 && f.duplicate == 0 && f.reorder == 0 {
+		delete(t.faults, eventKind)
+	}
+	return event, nil
+}
+
+func (t *Transport) fault(eventKind string) *fault {
+	f, ok := t.faults[eventKind]
+	if !ok {
+		f = &fault{}
+		t.faults[eventKind] = f
+	}
+	return f
+}
+
+// subscribed returns true if eventKind matches any prefix subscribed for.
+// t.mu must be locked.
+func (t *Transport) subscribed(eventKind string) bool {
+	for prefix := range t.subscriptions {
+		if strings.HasPrefix(eventKind, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue appends items to the delivery queue. t.mu must be locked.
+func (t *Transport) enqueue(items ...interface{}) {
+	t.queue = append(t.queue, items...)
+	select {
+	case t.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// pump delivers the queued events, tables and acknowledgements in order.
+// The queue is unbounded so that Publish and Subscribe never block, they are
+// being called while the Service is holding its lock.
+func (t *Transport) pump() {
+	for {
+		t.mu.Lock()
+		queue := t.queue
+		t.queue = nil
+		t.mu.Unlock()
+
+		if len(queue) == 0 {
+			select {
+			case <-t.wakeCh:
+				continue
+			case <-t.closedCh:
+				return
+			}
+		}
+
+		for _, item := range queue {
+			switch item := item.(type) {
+			case *Event:
+				select {
+				case t.eventCh <- item:
+				case <-t.closedCh:
+					return
+				}
+			case pubsub.EventSeqTable:
+				select {
+				case t.tableCh <- item:
+				case <-t.closedCh:
+					return
+				}
+			case *pubsub.PublishedEvent:
+				select {
+				case t.ackCh <- item:
+				case <-t.closedCh:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrTerminated = &services.ErrTerminated{"pubsubtest transport"}
+	ErrTimeout    = errors.New("timed out waiting for events")
+)
+
+type ErrNotSubscribed struct {
+	EventKindPrefix string
+}
+
+func (err *ErrNotSubscribed) Error() string {
+	return "not subscribed for " + err.EventKindPrefix
+}
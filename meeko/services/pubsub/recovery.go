@@ -0,0 +1,221 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultGapRecoveryTimeout is how long to wait for the missed events
+// unless specified otherwise, see EnableGapRecovery.
+const DefaultGapRecoveryTimeout = 5 * time.Second
+
+// EventFetcher fetches the events of eventKind with sequence numbers in
+// the range from-to, inclusive. It is used for recovering the events that
+// were lost on the way from the broker. It is fine to return only some of
+// the events when not all of them are available.
+//
+// See the replay package for an EventFetcher implementation using RPC.
+type EventFetcher func(eventKind string, from, to EventSeqNum) ([]Event, error)
+
+// EnableGapRecovery makes the service use fetcher to get the events that were
+// missed whenever a gap in event sequence numbers is detected. The missed
+// events are delivered before the event that made the service consider them
+// lost, see ErrEventSequenceGap.
+//
+// fetcher is run in a separate goroutine. The events of the kind affected are
+// held back until fetcher returns or timeout elapses, zero meaning
+// DefaultGapRecoveryTimeout, the other events are being processed as usual.
+// Passing nil fetcher disables gap recovery, which is the default.
+func (srv *Service) EnableGapRecovery(fetcher EventFetcher, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DefaultGapRecoveryTimeout
+	}
+
+	srv.mu.Lock()
+	srv.fetcher = fetcher
+	srv.recoveryTimeout = timeout
+	srv.mu.Unlock()
+}
+
+// gapRecovery represents the missed events of a kind being fetched.
+type gapRecovery struct {
+	kind string
+	gaps []*ErrEventSequenceGap
+
+	// The events received while fetching, starting with the one that revealed
+	// the gap. Only accessed from within the service loop.
+	held []*heldEvent
+
+	// The result, set before the recovery is sent to recoveredCh.
+	// There is an error for every gap, nil meaning success.
+	events []Event
+	errs   []error
+}
+
+// heldEvent is an event waiting for a gap recovery to finish. event is nil
+// when the gaps were not revealed by an event, but by the gap timeout.
+type heldEvent struct {
+	event   Event
+	gaps    []*ErrEventSequenceGap
+	records []*handlerRecord
+}
+
+// Gap recovery management -----------------------------------------------------
+//
+// All the methods below are only called from within the service loop.
+
+// startRecovery starts fetching the events missing because of the gaps
+// found when held.event was received in the background.
+func (srv *Service) startRecovery(fetcher EventFetcher, timeout time.Duration, held *heldEvent) {
+	recovery := &gapRecovery{
+		kind: held.gaps[0].EventKind,
+		gaps: held.gaps,
+		held: []*heldEvent{held},
+	}
+	srv.recoveries[recovery.kind] = recovery
+
+	go func() {
+		type result struct {
+			events []Event
+			errs   []error
+		}
+		// The fetcher cannot be interrupted, so its result is dropped
+		// in case it arrives too late.
+		resultCh := make(chan *result, 1)
+		go func() {
+			res := &result{errs: make([]error, len(recovery.gaps))}
+			for i, gap := range recovery.gaps {
+				from, to := gap.ExpectedSeq, prevSeq(gap.ReceivedSeq, gap.ExpectedSeq)
+				events, err := fetcher(gap.EventKind, from, to)
+				res.events = append(res.events, events...)
+				res.errs[i] = err
+			}
+			resultCh <- res
+		}()
+
+		select {
+		case res := <-resultCh:
+			recovery.events, recovery.errs = res.events, res.errs
+		case <-time.After(timeout):
+			recovery.errs = make([]error, len(recovery.gaps))
+			for i := range recovery.errs {
+				recovery.errs[i] = ErrGapRecoveryTimeout
+			}
+		}
+
+		select {
+		case srv.recoveredCh <- recovery:
+		case <-srv.closedCh:
+		}
+	}()
+}
+
+// finishRecovery dispatches the events recovered and then the events held
+// back in the meantime.
+func (srv *Service) finishRecovery(recovery *gapRecovery) {
+	delete(srv.recoveries, recovery.kind)
+
+	srv.mu.Lock()
+	var (
+		fetcher = srv.fetcher
+		timeout = srv.recoveryTimeout
+		limits  = srv.limits
+	)
+	srv.mu.Unlock()
+
+	for i, gap := range recovery.gaps {
+		if err := recovery.errs[i]; err != nil {
+			srv.report(&ErrGapRecovery{gap, err})
+			continue
+		}
+		srv.dispatchRecovered(gap, recovery.events, recovery.held[0].records, limits)
+	}
+
+	for i, held := range recovery.held {
+		// Other gaps, the rest of the events is held back again.
+		if i != 0 && len(held.gaps) != 0 {
+			if fetcher != nil {
+				srv.startRecovery(fetcher, timeout, held)
+				next := srv.recoveries[recovery.kind]
+				next.held = append(next.held, recovery.held[i+1:]...)
+				return
+			}
+			for _, gap := range held.gaps {
+				srv.report(gap)
+			}
+		}
+
+		if held.event == nil {
+			continue
+		}
+		for _, record := range srv.selectRecords(held.event, held.records) {
+			srv.dispatch(limits, record, held.event)
+		}
+	}
+}
+
+// dispatchRecovered dispatches the events fetched to fill gap, in order,
+// to records.
+func (srv *Service) dispatchRecovered(gap *ErrEventSequenceGap, events []Event,
+	records []*handlerRecord, limits *HandlerLimits) {
+
+	var (
+		from    = gap.ExpectedSeq
+		missing = SeqDistance(from, gap.ReceivedSeq)
+		byDist  = make(map[int64]Event, len(events))
+	)
+
+	// Make sure only the requested events are delivered, in order.
+	for _, event := range events {
+		d := SeqDistance(from, event.Seq())
+		if event.Kind() != gap.EventKind || d < 0 || d >= missing {
+			continue
+		}
+		byDist[d] = event
+	}
+
+	var recovered int64
+	for ; recovered < missing; recovered++ {
+		event, ok := byDist[recovered]
+		if !ok {
+			break
+		}
+
+		srv.mu.Lock()
+		if window, ok := srv.seqWindowsByKind[gap.EventKind]; ok {
+			window.markReceived(event.Seq())
+		}
+		srv.mu.Unlock()
+
+		for _, record := range srv.selectRecords(event, records) {
+			srv.dispatch(limits, record, event)
+		}
+	}
+
+	if recovered != missing {
+		srv.report(&ErrGapRecovery{gap, ErrIncompleteRecovery})
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrGapRecovery struct {
+	Gap *ErrEventSequenceGap
+	Err error
+}
+
+func (err *ErrGapRecovery) Error() string {
+	return fmt.Sprintf("Failed to recover from event sequence gap for %v: %v",
+		err.Gap.EventKind, err.Err)
+}
+
+var (
+	ErrIncompleteRecovery = errors.New("some events are not available any more")
+	ErrGapRecoveryTimeout = errors.New("timed out fetching the missed events")
+)
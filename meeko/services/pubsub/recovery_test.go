@@ -0,0 +1,163 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+// publishedFetcher returns an EventFetcher serving the events published
+// through transport. It signals calledCh and waits for releaseCh first.
+func publishedFetcher(transport *pubsubtest.Transport,
+	calledCh chan<- struct{}, releaseCh <-chan struct{}) pubsub.EventFetcher {
+
+	return func(eventKind string, from, to pubsub.EventSeqNum) ([]pubsub.Event, error) {
+		calledCh <- struct{}{}
+		<-releaseCh
+
+		var events []pubsub.Event
+		for _, event := range transport.Published() {
+			if event.Kind() == eventKind && event.Seq() >= from && event.Seq() <= to {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
+}
+
+// subscribeOrdered subscribes rec for eventKindPrefix using DeliveryOrdered,
+// so that the events are recorded in the order dispatched.
+func subscribeOrdered(t *testing.T, srv *pubsub.Service, eventKindPrefix string,
+	rec *pubsubtest.Recorder) {
+
+	_, err := srv.SubscribeWithOptions(eventKindPrefix, rec.Handle, &pubsub.SubscriptionOptions{
+		Delivery: pubsub.DeliveryOrdered,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recordedSeqs(rec *pubsubtest.Recorder) []int {
+	var seqs []int
+	for _, event := range rec.Events() {
+		seqs = append(seqs, int(event.Seq()))
+	}
+	return seqs
+}
+
+// publishWithGap publishes events 1-20 of kind a, event 2 being dropped.
+// The gap is detected once event 18 is received.
+func publishWithGap(t *testing.T, srv *pubsub.Service, transport *pubsubtest.Transport) {
+	for i := 1; i <= 20; i++ {
+		if i == 2 {
+			transport.DropNext("a", 1)
+		}
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitCalled(t *testing.T, calledCh <-chan struct{}) {
+	select {
+	case <-calledCh:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the fetcher to be called")
+	}
+}
+
+func TestGapRecovery(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	var (
+		calledCh  = make(chan struct{}, 1)
+		releaseCh = make(chan struct{})
+	)
+	srv.EnableGapRecovery(publishedFetcher(transport, calledCh, releaseCh), testTimeout)
+
+	rec := pubsubtest.NewRecorder()
+	subscribeOrdered(t, srv, "a", rec)
+
+	publishWithGap(t, srv, transport)
+	waitCalled(t, calledCh)
+
+	// The events received after the gap was detected are held back.
+	if err := rec.Wait(16, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	barrier(t, srv)
+	if n := rec.Count(); n != 16 {
+		t.Fatalf("expected 16 events delivered while fetching, got %v", n)
+	}
+
+	close(releaseCh)
+	if err := rec.Wait(20, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{1, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 2, 18, 19, 20}
+	if seqs := recordedSeqs(rec); !equalInts(seqs, expected) {
+		t.Errorf("expected %v, got %v", expected, seqs)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
+
+func TestGapRecovery_Timeout(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	var (
+		calledCh  = make(chan struct{}, 1)
+		releaseCh = make(chan struct{})
+	)
+	defer close(releaseCh)
+	srv.EnableGapRecovery(publishedFetcher(transport, calledCh, releaseCh), 50*time.Millisecond)
+
+	rec := pubsubtest.NewRecorder()
+	subscribeOrdered(t, srv, "a", rec)
+
+	publishWithGap(t, srv, transport)
+	waitCalled(t, calledCh)
+
+	select {
+	case err := <-errCh:
+		rerr, ok := err.(*pubsub.ErrGapRecovery)
+		if !ok {
+			t.Fatalf("expected ErrGapRecovery, got %v", err)
+		}
+		if rerr.Err != pubsub.ErrGapRecoveryTimeout {
+			t.Errorf("expected ErrGapRecoveryTimeout, got %v", rerr.Err)
+		}
+		if gap := rerr.Gap; gap.ExpectedSeq != 2 || gap.ReceivedSeq != 3 {
+			t.Errorf("unexpected gap: %v", gap)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the error")
+	}
+
+	// The events held back are delivered anyway.
+	if err := rec.Wait(19, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{1, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	if seqs := recordedSeqs(rec); !equalInts(seqs, expected) {
+		t.Errorf("expected %v, got %v", expected, seqs)
+	}
+}
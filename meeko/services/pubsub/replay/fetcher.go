@@ -0,0 +1,96 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package replay
+
+import (
+	// Stdlib
+	"bytes"
+	"fmt"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// NewFetcher returns a pubsub.EventFetcher requesting the missed events from
+// a Recorder exporting them as method. The result is supposed to be passed
+// to pubsub.Service.EnableGapRecovery.
+func NewFetcher(rpcSrv *rpc.Service, method string) pubsub.EventFetcher {
+	return func(eventKind string, from, to pubsub.EventSeqNum) ([]pubsub.Event, error) {
+		call := rpcSrv.NewRemoteCall(method, &Args{eventKind, from, to})
+		if err := call.Execute(); err != nil {
+			return nil, err
+		}
+
+		if rc := call.ReturnCode(); rc != rpc.ReturnCodeSuccess {
+			var msg string
+			call.UnmarshalReturnValue(&msg)
+			return nil, &ErrReplay{method, rc, msg}
+		}
+
+		var records []*Record
+		if err := call.UnmarshalReturnValue(&records); err != nil {
+			return nil, err
+		}
+
+		events := make([]pubsub.Event, len(records))
+		for i, record := range records {
+			events[i] = &event{eventKind, record}
+		}
+		return events, nil
+	}
+}
+
+// event implements pubsub.Event for the replayed events.
+type event struct {
+	kind   string
+	record *Record
+}
+
+func (e *event) Kind() string {
+	return e.kind
+}
+
+func (e *event) Seq() pubsub.EventSeqNum {
+	return e.record.Seq
+}
+
+func (e *event) Publisher() string {
+	return e.record.Publisher
+}
+
+func (e *event) Id() string {
+	return e.record.Id
+}
+
+func (e *event) Timestamp() time.Time {
+	if e.record.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, e.record.Timestamp)
+}
+
+func (e *event) Headers() pubsub.EventHeaders {
+	return pubsub.EventHeaders(e.record.Headers)
+}
+
+func (e *event) Unmarshal(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(e.record.Body), dst)
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrReplay struct {
+	Method     string
+	ReturnCode rpc.ReturnCode
+	Message    string
+}
+
+func (err *ErrReplay) Error() string {
+	return fmt.Sprintf("%v returned %v: %v", err.Method, err.ReturnCode, err.Message)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package replay makes it possible for PubSub subscribers to recover events
+// they have missed. A Recorder keeps the recent events published by a service
+// in memory and exports them over RPC, NewFetcher returns a pubsub.EventFetcher
+// requesting them.
+package replay
+
+import (
+	// Stdlib
+	"errors"
+	"sync"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+)
+
+// DefaultBufferSize is the number of events kept for every event kind
+// unless specified otherwise.
+const DefaultBufferSize = 1000
+
+// ReturnCodeInvalidArgs is returned by the replay RPC method when the request
+// cannot be decoded or the sequence number range is invalid.
+const ReturnCodeInvalidArgs rpc.ReturnCode = 1
+
+// Args are the arguments of the replay RPC method.
+type Args struct {
+	Kind string
+	From pubsub.EventSeqNum
+	To   pubsub.EventSeqNum
+}
+
+// Record is a recorded event as returned by the replay RPC method.
+// Timestamp is in nanoseconds since the Unix epoch, zero meaning not set.
+type Record struct {
+	Seq       pubsub.EventSeqNum
+	Publisher string
+	Id        string
+	Timestamp int64
+	Headers   map[string]string
+	Body      []byte
+}
+
+// Recorder records the events matching a kind prefix published by a service
+// into a bounded ring buffer per event kind and serves them using an RPC method.
+type Recorder struct {
+	rpcSrv *rpc.Service
+	method string
+	size   int
+
+	cancel func()
+	rings  map[string][]*Record
+	mu     *sync.Mutex
+}
+
+// NewRecorder starts recording the events with kind starting with
+// eventKindPrefix published by pubsubSrv and exports them as method.
+// bufferSize is the number of events kept for every event kind, zero meaning
+// DefaultBufferSize.
+//
+// The events are recorded on the publisher side as soon as the broker assigns
+// them sequence numbers, see pubsub.Service.OnPublished, so the transport used
+// by pubsubSrv must be able to acknowledge the events published. Keep in mind
+// that it is also up to the broker to send the acknowledgements. Only
+// the embedded zmq3 broker does that, meekod does not, in which case nothing
+// is ever recorded.
+//
+// The events are served by kind, no matter which publisher they come from.
+// When there are multiple publishers of the same event kind, the recorders
+// exporting the same method cannot be told apart and any of them can answer,
+// returning events that the subscriber has not actually missed. Make sure
+// there is a single publisher for every event kind being recorded.
+func NewRecorder(pubsubSrv *pubsub.Service, rpcSrv *rpc.Service,
+	eventKindPrefix, method string, bufferSize int) (*Recorder, error) {
+
+	if bufferSize < 0 {
+		return nil, ErrInvalidBufferSize
+	}
+	if bufferSize == 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	rec := &Recorder{
+		rpcSrv: rpcSrv,
+		method: method,
+		size:   bufferSize,
+		rings:  make(map[string][]*Record),
+		mu:     new(sync.Mutex),
+	}
+
+	if err := rpcSrv.RegisterMethod(method, rec.handleRequest); err != nil {
+		return nil, err
+	}
+
+	cancel, err := pubsubSrv.OnPublished(eventKindPrefix, rec.record)
+	if err != nil {
+		rpcSrv.UnregisterMethod(method)
+		return nil, err
+	}
+	rec.cancel = cancel
+
+	return rec, nil
+}
+
+// Close stops recording and unregisters the replay method.
+func (rec *Recorder) Close() error {
+	rec.cancel()
+	return rec.rpcSrv.UnregisterMethod(rec.method)
+}
+
+func (rec *Recorder) record(event *pubsub.PublishedEvent) {
+	record := &Record{
+		Seq:       event.Seq,
+		Publisher: event.Publisher,
+		Body:      event.Body,
+	}
+	if props := event.Props; props != nil {
+		record.Id = props.Id
+		record.Headers = props.Headers
+		if !props.Timestamp.IsZero() {
+			record.Timestamp = props.Timestamp.UnixNano()
+		}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	ring, ok := rec.rings[event.Kind]
+	if !ok {
+		ring = make([]*Record, rec.size)
+		rec.rings[event.Kind] = ring
+	}
+	ring[int(event.Seq%pubsub.EventSeqNum(rec.size))] = record
+}
+
+func (rec *Recorder) handleRequest(request rpc.RemoteRequest) {
+	var args Args
+	if err := request.UnmarshalArgs(&args); err != nil {
+		request.Resolve(ReturnCodeInvalidArgs, err.Error())
+		return
+	}
+	if pubsub.SeqDistance(args.From, args.To) < 0 {
+		request.Resolve(ReturnCodeInvalidArgs, "invalid sequence number range")
+		return
+	}
+
+	request.Resolve(rpc.ReturnCodeSuccess, rec.lookup(&args))
+}
+
+// lookup returns the events requested by args that are still available.
+func (rec *Recorder) lookup(args *Args) []*Record {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	ring, ok := rec.rings[args.Kind]
+	if !ok {
+		return []*Record{}
+	}
+
+	// Sequence numbers can wrap around, so the whole buffer is scanned
+	// instead of computing the indexes of the records requested.
+	var (
+		n       = pubsub.SeqDistance(args.From, args.To) + 1
+		byDist  = make(map[int64]*Record)
+		records = []*Record{}
+	)
+	for _, r := range ring {
+		if r == nil {
+			continue
+		}
+		if d := pubsub.SeqDistance(args.From, r.Seq); d >= 0 && d < n {
+			byDist[d] = r
+		}
+	}
+	for d := int64(0); d < n && len(records) < len(byDist); d++ {
+		if r, ok := byDist[d]; ok {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrInvalidBufferSize = errors.New("invalid replay buffer size")
@@ -0,0 +1,330 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package replica keeps a local replica of some remote state. The initial
+// state is fetched from an RPC method as a Snapshot and then the deltas
+// published using PubSub are applied to it.
+//
+// The sequence numbers in the snapshot are used to discard the deltas that are
+// already included in the snapshot. The snapshot is fetched again whenever
+// a delta is missed or it cannot be applied.
+package replica
+
+import (
+	// Stdlib
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+)
+
+// DefaultRetryInterval is how long to wait before fetching the snapshot again
+// after a failure unless specified otherwise.
+const DefaultRetryInterval = time.Second
+
+// Config specifies what state to replicate and how.
+type Config struct {
+	// The deltas are the events with kind starting with EventKindPrefix.
+	EventKindPrefix string
+
+	// The RPC method returning Snapshot and its arguments.
+	Method string
+	Args   interface{}
+
+	// Load replaces the local state with the snapshot.
+	// Apply applies a delta to the local state.
+	//
+	// These are never called concurrently and the replica is locked while
+	// they are running, so they must not call any Replica methods.
+	Load  func(snapshot *Snapshot) error
+	Apply func(delta pubsub.Event) error
+
+	// How long to wait before fetching the snapshot again after a failure,
+	// zero meaning DefaultRetryInterval.
+	RetryInterval time.Duration
+}
+
+// Replica applies the deltas to the state received in a snapshot.
+type Replica struct {
+	pubsubSrv *pubsub.Service
+	rpcSrv    *rpc.Service
+	config    Config
+
+	listener *pubsub.Listener
+
+	// The sequence number of the last delta applied for every event kind.
+	seqs map[string]pubsub.EventSeqNum
+
+	// The deltas received while fetching the snapshot.
+	syncing bool
+	buffer  []pubsub.Event
+
+	monitorCh chan<- error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        *sync.Mutex
+}
+
+// New subscribes for the deltas, fetches the snapshot and applies the deltas
+// received in the meantime. ctx can be used to abort the initial
+// synchronization, the replica is then closed.
+//
+// The errors encountered later on are sent to the channel registered using
+// Monitor and the snapshot is fetched again.
+func New(ctx context.Context, pubsubSrv *pubsub.Service, rpcSrv *rpc.Service,
+	config *Config) (*Replica, error) {
+
+	if config.Method == "" || config.Load == nil || config.Apply == nil {
+		return nil, ErrInvalidConfig
+	}
+
+	r := &Replica{
+		pubsubSrv: pubsubSrv,
+		rpcSrv:    rpcSrv,
+		config:    *config,
+		syncing:   true,
+		mu:        new(sync.Mutex),
+	}
+	if r.config.RetryInterval == 0 {
+		r.config.RetryInterval = DefaultRetryInterval
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	// The deltas must be applied in the order they were published.
+	listener, baseline, err := pubsubSrv.SubscribeSync(ctx, config.EventKindPrefix,
+		r.handleDelta, &pubsub.SubscriptionOptions{Delivery: pubsub.DeliveryOrdered})
+	if err != nil {
+		r.cancel()
+		return nil, err
+	}
+	r.listener = listener
+
+	if err := r.sync(ctx, baseline); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Monitor registers errChan for receiving the errors encountered after
+// the replica was created. The replica never blocks on errChan.
+//
+// Possible error types that can be received on this channel:
+//   - *pubsub.ErrEventSequenceGap - a delta was missed
+//   - *ErrApply - a delta could not be applied
+//   - *ErrSnapshot - the snapshot method returned an error
+//   - any error returned by the RPC service or by Load
+func (r *Replica) Monitor(errChan chan<- error) {
+	r.mu.Lock()
+	r.monitorCh = errChan
+	r.mu.Unlock()
+}
+
+// Synced returns false while the snapshot is being fetched again.
+func (r *Replica) Synced() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.syncing
+}
+
+// Close stops applying the deltas.
+func (r *Replica) Close() error {
+	r.cancel()
+	return r.listener.Close()
+}
+
+// sync fetches the snapshot, loads it and applies the deltas buffered
+// in the meantime. baseline contains the sequence numbers to be used for
+// the event kinds missing in the snapshot, it can be nil.
+func (r *Replica) sync(ctx context.Context, baseline pubsub.EventSeqTable) error {
+	snapshot, err := r.fetchSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.config.Load(snapshot); err != nil {
+		return err
+	}
+
+	r.seqs = make(map[string]pubsub.EventSeqNum, len(baseline)+len(snapshot.Seqs))
+	for kind, seq := range baseline {
+		r.seqs[kind] = seq
+	}
+	for kind, seq := range snapshot.Seqs {
+		r.seqs[kind] = seq
+	}
+
+	buffer := r.buffer
+	r.buffer = nil
+	r.syncing = false
+	for i, delta := range buffer {
+		if !r.applyDelta(delta) {
+			// Synchronizing again, keep the remaining deltas.
+			r.buffer = append(r.buffer, buffer[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *Replica) fetchSnapshot(ctx context.Context) (*Snapshot, error) {
+	call := r.rpcSrv.NewRemoteCall(r.config.Method, r.config.Args).GoExecute()
+	select {
+	case <-call.Resolved():
+	case <-ctx.Done():
+		call.Abandon()
+		return nil, ctx.Err()
+	case <-r.ctx.Done():
+		call.Abandon()
+		return nil, r.ctx.Err()
+	}
+	if err := call.Wait(); err != nil {
+		return nil, err
+	}
+
+	if rc := call.ReturnCode(); rc != rpc.ReturnCodeSuccess {
+		var msg string
+		call.UnmarshalReturnValue(&msg)
+		return nil, &ErrSnapshot{r.config.Method, rc, msg}
+	}
+
+	var snapshot Snapshot
+	if err := call.UnmarshalReturnValue(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *Replica) handleDelta(delta pubsub.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.syncing {
+		r.buffer = append(r.buffer, delta)
+		return
+	}
+	r.applyDelta(delta)
+}
+
+// applyDelta applies delta unless it is included in the state already.
+// It returns false when the snapshot is to be fetched again, the delta being
+// buffered to be applied afterwards. r.mu must be locked.
+func (r *Replica) applyDelta(delta pubsub.Event) bool {
+	kind, seq := delta.Kind(), delta.Seq()
+
+	// The kinds not known yet are tracked from their first delta.
+	if last, ok := r.seqs[kind]; ok {
+		switch d := pubsub.SeqDistance(last, seq); {
+		case d <= 0:
+			// Included in the snapshot already.
+			return true
+		case d > 1:
+			r.monitor(&pubsub.ErrEventSequenceGap{
+				EventKind:   kind,
+				ExpectedSeq: pubsub.SeqOffset(last, 1, seq),
+				ReceivedSeq: seq,
+			})
+			// The gap cannot be filled unless the snapshot includes the kind,
+			// it is tracked from this delta again otherwise.
+			baseline := r.seqTable()
+			delete(baseline, kind)
+			r.resync(delta, baseline)
+			return false
+		}
+	}
+
+	if err := r.config.Apply(delta); err != nil {
+		r.monitor(&ErrApply{kind, seq, err})
+		r.resync(delta, r.seqTable())
+		return false
+	}
+	r.seqs[kind] = seq
+	return true
+}
+
+// seqTable returns the sequence numbers of the last deltas applied.
+// r.mu must be locked.
+func (r *Replica) seqTable() pubsub.EventSeqTable {
+	table := make(pubsub.EventSeqTable, len(r.seqs))
+	for kind, seq := range r.seqs {
+		table[kind] = seq
+	}
+	return table
+}
+
+// resync starts fetching the snapshot again in the background, buffering
+// delta to be applied afterwards. baseline is passed to sync, so that
+// the kinds missing in the snapshot are still checked for gaps.
+// r.mu must be locked.
+func (r *Replica) resync(delta pubsub.Event, baseline pubsub.EventSeqTable) {
+	r.buffer = append(r.buffer, delta)
+	if r.syncing {
+		return
+	}
+	r.syncing = true
+
+	go func() {
+		for {
+			err := r.sync(r.ctx, baseline)
+			if err == nil {
+				return
+			}
+
+			r.mu.Lock()
+			r.monitor(err)
+			r.mu.Unlock()
+
+			select {
+			case <-time.After(r.config.RetryInterval):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// monitor sends err to the monitoring channel if there is any.
+// r.mu must be locked.
+func (r *Replica) monitor(err error) {
+	if r.monitorCh == nil {
+		return
+	}
+	select {
+	case r.monitorCh <- err:
+	default:
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrInvalidConfig = errors.New("replica config incomplete")
+
+type ErrSnapshot struct {
+	Method     string
+	ReturnCode rpc.ReturnCode
+	Message    string
+}
+
+func (err *ErrSnapshot) Error() string {
+	return fmt.Sprintf("%v returned %v: %v", err.Method, err.ReturnCode, err.Message)
+}
+
+type ErrApply struct {
+	EventKind string
+	Seq       pubsub.EventSeqNum
+	Err       error
+}
+
+func (err *ErrApply) Error() string {
+	return fmt.Sprintf("Failed to apply delta %v #%v: %v", err.EventKind, err.Seq, err.Err)
+}
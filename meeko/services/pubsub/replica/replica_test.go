@@ -0,0 +1,321 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package replica_test
+
+import (
+	// Stdlib
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/replica"
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/services/rpc/rpctest"
+)
+
+const (
+	testTimeout = 5 * time.Second
+	testMethod  = "snapshot"
+)
+
+var errApply = errors.New("apply failed")
+
+// testEnv holds the services used by the replica and their transports.
+type testEnv struct {
+	pubsubSrv       *pubsub.Service
+	pubsubTransport *pubsubtest.Transport
+	rpcSrv          *rpc.Service
+	rpcTransport    *rpctest.Transport
+
+	// Every snapshot loaded and every delta applied is sent to these.
+	loadedCh  chan *replica.Snapshot
+	appliedCh chan pubsub.EventSeqNum
+
+	// Apply fails once for the delta with this sequence number.
+	failSeq pubsub.EventSeqNum
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	env := &testEnv{
+		pubsubTransport: pubsubtest.NewTransport("test"),
+		rpcTransport:    rpctest.NewTransport("test"),
+		loadedCh:        make(chan *replica.Snapshot, 10),
+		appliedCh:       make(chan pubsub.EventSeqNum, 100),
+	}
+
+	var err error
+	env.pubsubSrv, err = pubsub.NewService(env.pubsubTransport.Factory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.rpcSrv, err = rpc.NewService(env.rpcTransport.Factory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func (env *testEnv) Close() {
+	env.pubsubSrv.Close()
+	env.rpcSrv.Close()
+}
+
+func (env *testEnv) config() *replica.Config {
+	return &replica.Config{
+		EventKindPrefix: "a",
+		Method:          testMethod,
+		Load: func(snapshot *replica.Snapshot) error {
+			env.loadedCh <- snapshot
+			return nil
+		},
+		Apply: func(delta pubsub.Event) error {
+			if delta.Seq() == env.failSeq {
+				env.failSeq = 0
+				return errApply
+			}
+			env.appliedCh <- delta.Seq()
+			return nil
+		},
+		RetryInterval: 10 * time.Millisecond,
+	}
+}
+
+// setSnapshot makes the snapshot method return a snapshot including
+// the deltas up to seqs.
+func (env *testEnv) setSnapshot(t *testing.T, seqs pubsub.EventSeqTable) {
+	snapshot, err := replica.NewSnapshot(seqs, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.rpcTransport.SetReply(testMethod, &rpctest.Reply{ReturnValue: snapshot})
+}
+
+// publish publishes n deltas.
+func (env *testEnv) publish(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		if err := env.pubsubSrv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitLoaded waits for a snapshot to be loaded.
+func (env *testEnv) waitLoaded(t *testing.T) *replica.Snapshot {
+	select {
+	case snapshot := <-env.loadedCh:
+		return snapshot
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the snapshot to be loaded")
+	}
+	return nil
+}
+
+// waitApplied waits for n deltas to be applied and returns their sequence
+// numbers. It fails in case more deltas are applied right after that.
+func (env *testEnv) waitApplied(t *testing.T, n int) []pubsub.EventSeqNum {
+	var seqs []pubsub.EventSeqNum
+	for i := 0; i < n; i++ {
+		select {
+		case seq := <-env.appliedCh:
+			seqs = append(seqs, seq)
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for delta %v, got %v", i, seqs)
+		}
+	}
+	select {
+	case seq := <-env.appliedCh:
+		t.Fatalf("unexpected delta %v applied after %v", seq, seqs)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return seqs
+}
+
+// syncWithDeltas creates a replica, publishing n deltas while the replica
+// is fetching the snapshot. The snapshot includes the deltas up to seqs.
+func (env *testEnv) syncWithDeltas(t *testing.T, n int, seqs pubsub.EventSeqTable) *replica.Replica {
+	type result struct {
+		r   *replica.Replica
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		r, err := replica.New(context.Background(), env.pubsubSrv, env.rpcSrv, env.config())
+		resultCh <- result{r, err}
+	}()
+
+	// No reply is registered, so the call is pending until replied to.
+	var call *rpctest.Call
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if calls := env.rpcTransport.CallsOf(testMethod); len(calls) != 0 {
+			call = calls[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the snapshot to be requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	env.publish(t, n)
+	if err := pubsubtest.WaitHandled(env.pubsubSrv, n, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.appliedCh) != 0 {
+		t.Fatal("deltas applied before the snapshot was loaded")
+	}
+
+	snapshot, err := replica.NewSnapshot(seqs, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.rpcTransport.ReplyTo(call, &rpctest.Reply{ReturnValue: snapshot}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-resultCh:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.r
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the replica to be synced")
+	}
+	return nil
+}
+
+func equalSeqs(a []pubsub.EventSeqNum, b ...pubsub.EventSeqNum) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplica_BuffersWhileSyncing(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	r := env.syncWithDeltas(t, 3, nil)
+	defer r.Close()
+
+	env.waitLoaded(t)
+	if seqs := env.waitApplied(t, 3); !equalSeqs(seqs, 1, 2, 3) {
+		t.Errorf("expected [1 2 3] applied, got %v", seqs)
+	}
+	if !r.Synced() {
+		t.Error("expected the replica to be synced")
+	}
+
+	// The deltas are applied directly once synced.
+	env.publish(t, 1)
+	if seqs := env.waitApplied(t, 1); !equalSeqs(seqs, 4) {
+		t.Errorf("expected [4] applied, got %v", seqs)
+	}
+}
+
+func TestReplica_DiscardsDeltasInSnapshot(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	r := env.syncWithDeltas(t, 4, pubsub.EventSeqTable{"a": 2})
+	defer r.Close()
+
+	env.waitLoaded(t)
+	if seqs := env.waitApplied(t, 2); !equalSeqs(seqs, 3, 4) {
+		t.Errorf("expected [3 4] applied, got %v", seqs)
+	}
+}
+
+func TestReplica_ResyncOnApplyError(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+	env.setSnapshot(t, nil)
+	env.failSeq = 2
+
+	r, err := replica.New(context.Background(), env.pubsubSrv, env.rpcSrv, env.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	errCh := make(chan error, 10)
+	r.Monitor(errCh)
+	env.waitLoaded(t)
+
+	env.publish(t, 3)
+
+	select {
+	case err := <-errCh:
+		aerr, ok := err.(*replica.ErrApply)
+		if !ok {
+			t.Fatalf("expected ErrApply, got %v", err)
+		}
+		if aerr.EventKind != "a" || aerr.Seq != 2 || aerr.Err != errApply {
+			t.Errorf("unexpected error: %+v", aerr)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the error")
+	}
+
+	// The snapshot is fetched again and the delta that failed is retried.
+	env.waitLoaded(t)
+	if seqs := env.waitApplied(t, 3); !equalSeqs(seqs, 1, 2, 3) {
+		t.Errorf("expected [1 2 3] applied, got %v", seqs)
+	}
+	if n := len(env.rpcTransport.CallsOf(testMethod)); n != 2 {
+		t.Errorf("expected the snapshot to be fetched twice, got %v", n)
+	}
+}
+
+func TestReplica_ResyncOnGap(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+	env.setSnapshot(t, nil)
+
+	r, err := replica.New(context.Background(), env.pubsubSrv, env.rpcSrv, env.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	errCh := make(chan error, 10)
+	r.Monitor(errCh)
+	env.waitLoaded(t)
+
+	// Delta 2 is lost, the next snapshot includes it.
+	env.setSnapshot(t, pubsub.EventSeqTable{"a": 2})
+	env.publish(t, 1)
+	env.pubsubTransport.DropNext("a", 1)
+	env.publish(t, 2)
+
+	select {
+	case err := <-errCh:
+		gap, ok := err.(*pubsub.ErrEventSequenceGap)
+		if !ok {
+			t.Fatalf("expected ErrEventSequenceGap, got %v", err)
+		}
+		if gap.EventKind != "a" || gap.ExpectedSeq != 2 || gap.ReceivedSeq != 3 {
+			t.Errorf("unexpected gap: %+v", gap)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the gap to be reported")
+	}
+
+	if snapshot := env.waitLoaded(t); snapshot.Seqs["a"] != 2 {
+		t.Errorf("expected the second snapshot to be loaded, got %+v", snapshot)
+	}
+	if seqs := env.waitApplied(t, 2); !equalSeqs(seqs, 1, 3) {
+		t.Errorf("expected [1 3] applied, got %v", seqs)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package replica
+
+import (
+	// Stdlib
+	"bytes"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// Snapshot is the return value of the snapshot RPC method.
+type Snapshot struct {
+	// The sequence number of the last delta included in the state for every
+	// event kind. The deltas up to these sequence numbers are discarded.
+	Seqs map[string]pubsub.EventSeqNum
+
+	// The state encoded using MessagePack, see UnmarshalState.
+	State []byte
+}
+
+// NewSnapshot is supposed to be used by the snapshot RPC method to assemble
+// its return value. seqs must contain the sequence numbers of the last deltas
+// applied to state, which is encoded using MessagePack.
+func NewSnapshot(seqs pubsub.EventSeqTable, state interface{}) (*Snapshot, error) {
+	var buf bytes.Buffer
+	if err := codecs.MessagePack.Encode(&buf, state); err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Seqs:  map[string]pubsub.EventSeqNum(seqs),
+		State: buf.Bytes(),
+	}, nil
+}
+
+// UnmarshalState decodes the state into dst.
+func (snapshot *Snapshot) UnmarshalState(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(snapshot.State), dst)
+}
@@ -0,0 +1,559 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	// Other
+	"github.com/tchap/go-patricia/patricia"
+	"github.com/ugorji/go/codec"
+)
+
+// HeaderSchemaVersion is the event header carrying the schema version
+// the event object conforms to.
+const HeaderSchemaVersion = "schema-version"
+
+// FieldType is the type of a Schema field.
+type FieldType byte
+
+const (
+	FieldAny FieldType = iota
+	FieldString
+	FieldInt
+	FieldFloat
+	FieldBool
+	FieldBytes
+	FieldMap
+	FieldList
+)
+
+var fieldTypeNames = [...]string{
+	"any", "string", "int", "float", "bool", "bytes", "map", "list",
+}
+
+func (typ FieldType) String() string {
+	if int(typ) < len(fieldTypeNames) {
+		return fieldTypeNames[typ]
+	}
+	return "unknown"
+}
+
+// Field describes a single field of the event object.
+type Field struct {
+	Name     string
+	Type     FieldType
+	Required bool
+
+	// Fields of the nested object, only used for FieldMap.
+	// No fields mean that the content of the map is not checked.
+	Fields []*Field
+}
+
+// Schema describes the event objects published under certain event kinds.
+// The event objects are expected to be maps or structs.
+type Schema struct {
+	Version string
+	Fields  []*Field
+
+	// Strict schemas reject the fields that are not listed in Fields.
+	Strict bool
+}
+
+// SchemaFromType returns a schema derived from the struct type of prototype.
+// The fields are named the same way github.com/ugorji/go/codec names them.
+// All the fields are required except for pointers and omitempty fields.
+func SchemaFromType(prototype interface{}, version string) (*Schema, error) {
+	typ := reflect.TypeOf(prototype)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	return &Schema{
+		Version: version,
+		Fields:  structFields(typ),
+	}, nil
+}
+
+// codecField returns the name github.com/ugorji/go/codec encodes sf under.
+// Untagged embedded structs are inlined, their fields being encoded
+// as if they belonged to the parent struct.
+func codecField(sf reflect.StructField) (name string, omitEmpty, inline, ok bool) {
+	name = sf.Name
+	if tag := sf.Tag.Get("codec"); tag != "" {
+		parts := strings.Split(tag, ",")
+		if parts[0] == "-" {
+			return "", false, false, false
+		}
+		for _, opt := range parts[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		if parts[0] != "" {
+			return parts[0], omitEmpty, false, sf.PkgPath == ""
+		}
+	}
+
+	if sf.Anonymous {
+		typ := sf.Type
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() == reflect.Struct {
+			return "", omitEmpty, true, true
+		}
+	}
+	return name, omitEmpty, false, sf.PkgPath == ""
+}
+
+func structFields(typ reflect.Type) []*Field {
+	var (
+		fields   []*Field
+		embedded []*Field
+		names    = make(map[string]bool)
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name, omitEmpty, inline, ok := codecField(sf)
+		if !ok {
+			continue
+		}
+
+		if inline {
+			inner := sf.Type
+			for inner.Kind() == reflect.Ptr {
+				inner = inner.Elem()
+			}
+			for _, field := range structFields(inner) {
+				// The fields of a nil embedded pointer are not encoded at all.
+				if sf.Type.Kind() == reflect.Ptr || omitEmpty {
+					f := *field
+					f.Required = false
+					field = &f
+				}
+				embedded = append(embedded, field)
+			}
+			continue
+		}
+
+		field := &Field{
+			Name:     name,
+			Required: !omitEmpty && sf.Type.Kind() != reflect.Ptr,
+		}
+		field.Type, field.Fields = fieldType(sf.Type)
+		fields = append(fields, field)
+		names[name] = true
+	}
+
+	// The fields of the parent struct shadow the inlined ones.
+	for _, field := range embedded {
+		if !names[field.Name] {
+			fields = append(fields, field)
+			names[field.Name] = true
+		}
+	}
+	return fields
+}
+
+var (
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	selferType          = reflect.TypeOf((*codec.Selfer)(nil)).Elem()
+)
+
+// encodesItself returns true when typ takes care of its own encoding,
+// so the encoded form cannot be told from the kind of typ. That is also
+// the case of time.Time, which is a binary marshaler.
+func encodesItself(typ reflect.Type) bool {
+	ptr := reflect.PtrTo(typ)
+	return typ.Implements(binaryMarshalerType) || ptr.Implements(binaryMarshalerType) ||
+		typ.Implements(selferType) || ptr.Implements(selferType)
+}
+
+func fieldType(typ reflect.Type) (FieldType, []*Field) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if encodesItself(typ) {
+		return FieldAny, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return FieldString, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return FieldInt, nil
+	case reflect.Float32, reflect.Float64:
+		return FieldFloat, nil
+	case reflect.Bool:
+		return FieldBool, nil
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return FieldBytes, nil
+		}
+		return FieldList, nil
+	case reflect.Map:
+		return FieldMap, nil
+	case reflect.Struct:
+		return FieldMap, structFields(typ)
+	default:
+		return FieldAny, nil
+	}
+}
+
+// Validate checks v against the schema. v can be any object that can be
+// encoded using github.com/ugorji/go/codec. The object is inspected using
+// reflection as it would be encoded, it is not encoded to be validated.
+func (schema *Schema) Validate(v interface{}) error {
+	return schema.validate(v)
+}
+
+func (schema *Schema) validate(obj interface{}) error {
+	return validateFields("", reflect.ValueOf(obj), schema.Fields, schema.Strict)
+}
+
+func validateFields(path string, obj reflect.Value, fields []*Field, strict bool) error {
+	values, ok := fieldValues(indirect(obj))
+	if !ok {
+		return &ErrInvalidField{path, "not a map"}
+	}
+
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field.Name] = true
+		fieldPath := path + field.Name
+
+		value := indirect(values[field.Name])
+		if !value.IsValid() {
+			if field.Required {
+				return &ErrInvalidField{fieldPath, "missing"}
+			}
+			continue
+		}
+
+		if !field.Type.matches(value) {
+			return &ErrInvalidField{fieldPath, "not of type " + field.Type.String()}
+		}
+		if field.Type == FieldMap && len(field.Fields) != 0 {
+			if err := validateFields(fieldPath+".", value, field.Fields, strict); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strict {
+		for name := range values {
+			if !known[name] {
+				return &ErrInvalidField{path + name, "unknown field"}
+			}
+		}
+	}
+	return nil
+}
+
+// indirect follows pointers and interfaces, returning the zero Value for nil.
+// Nil slices and maps are encoded as nil as well.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		case reflect.Slice, reflect.Map:
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			return v
+		default:
+			return v
+		}
+	}
+	return v
+}
+
+// fieldValues returns the fields of v as they would be encoded, keyed by
+// their names. ok is false unless v is a struct or a map with string keys.
+func fieldValues(v reflect.Value) (values map[string]reflect.Value, ok bool) {
+	switch v.Kind() {
+	case reflect.Map:
+		values = make(map[string]reflect.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := indirect(iter.Key())
+			if key.Kind() != reflect.String {
+				return nil, false
+			}
+			values[key.String()] = iter.Value()
+		}
+		return values, true
+	case reflect.Struct:
+		values = make(map[string]reflect.Value, v.NumField())
+		structValues(v, values)
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+func structValues(v reflect.Value, values map[string]reflect.Value) {
+	var embedded []reflect.Value
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		name, omitEmpty, inline, ok := codecField(typ.Field(i))
+		if !ok {
+			continue
+		}
+
+		value := v.Field(i)
+		if omitEmpty && value.IsZero() {
+			continue
+		}
+		if inline {
+			if value = indirect(value); value.IsValid() {
+				embedded = append(embedded, value)
+			}
+			continue
+		}
+		values[name] = value
+	}
+
+	// The fields of the parent struct shadow the inlined ones.
+	for _, value := range embedded {
+		inner := make(map[string]reflect.Value)
+		structValues(value, inner)
+		for name, v := range inner {
+			if _, ok := values[name]; !ok {
+				values[name] = v
+			}
+		}
+	}
+}
+
+func (typ FieldType) matches(value reflect.Value) bool {
+	switch kind := value.Kind(); typ {
+	case FieldString:
+		return kind == reflect.String
+	case FieldInt:
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+		return false
+	case FieldFloat:
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			return true
+		}
+		return FieldInt.matches(value)
+	case FieldBool:
+		return kind == reflect.Bool
+	case FieldBytes:
+		// Raw bytes are decoded as strings.
+		return kind == reflect.String || isBytes(value)
+	case FieldMap:
+		switch kind {
+		case reflect.Struct:
+			return true
+		case reflect.Map:
+			_, ok := fieldValues(value)
+			return ok
+		}
+		return false
+	case FieldList:
+		return (kind == reflect.Slice || kind == reflect.Array) && !isBytes(value)
+	default:
+		return true
+	}
+}
+
+func isBytes(v reflect.Value) bool {
+	kind := v.Kind()
+	return (kind == reflect.Slice || kind == reflect.Array) && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+// Registry --------------------------------------------------------------------
+
+// schemaSet holds all the schema versions registered for a kind prefix.
+type schemaSet struct {
+	versions map[string]*Schema
+	latest   *Schema
+}
+
+// RegisterSchema registers schema for the event kinds starting with
+// eventKindPrefix. The longest registered prefix matching an event kind is
+// used. Multiple schema versions can be registered for the same prefix,
+// the last one registered being used for publishing.
+//
+// Publish validates the event objects against the schema. Invalid objects are
+// not published and *ErrSchemaViolation is returned. PublishWithHeaders also
+// attaches HeaderSchemaVersion to the events unless the header is set already,
+// in which case the relevant schema version is used. Publish does not send any
+// event properties, so the events are validated against the latest schema
+// version on the receiving side.
+//
+// Incoming events are only validated for the listeners created with
+// SubscriptionOptions.Validate set. Invalid events are not delivered to these
+// listeners and *ErrSchemaViolation is sent to the monitoring channel.
+func (srv *Service) RegisterSchema(eventKindPrefix string, schema *Schema) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.schemas == nil {
+		srv.schemas = patricia.NewTrie()
+	}
+
+	key := patricia.Prefix(eventKindPrefix)
+	set, ok := srv.schemas.Get(key).(*schemaSet)
+	if !ok {
+		set = &schemaSet{versions: make(map[string]*Schema)}
+		srv.schemas.Insert(key, set)
+	}
+	set.versions[schema.Version] = schema
+	set.latest = schema
+}
+
+// lookupSchema returns the schema for eventKind and version, version being
+// empty meaning the latest one. It returns nil when there is no schema set
+// registered for eventKind. srv.mu must be locked.
+func (srv *Service) lookupSchema(eventKind, version string) (*Schema, bool) {
+	if srv.schemas == nil {
+		return nil, false
+	}
+
+	var set *schemaSet
+	srv.schemas.VisitPrefixes(patricia.Prefix(eventKind),
+		func(prefix patricia.Prefix, item patricia.Item) error {
+			set = item.(*schemaSet)
+			return nil
+		})
+	if set == nil {
+		return nil, false
+	}
+
+	if version == "" {
+		return set.latest, true
+	}
+	return set.versions[version], true
+}
+
+// validateOutgoing checks eventObject before it is published and sets
+// the schema version header unless props is nil, meaning that the publisher
+// did not set any properties. No properties are sent in that case, see
+// PublishWithHeaders. The properties to be sent are returned.
+func (srv *Service) validateOutgoing(eventKind string, eventObject interface{},
+	props *EventProps) (*EventProps, error) {
+
+	var version string
+	if props != nil {
+		version = props.Headers[HeaderSchemaVersion]
+	}
+
+	srv.mu.Lock()
+	schema, ok := srv.lookupSchema(eventKind, version)
+	srv.mu.Unlock()
+	if !ok {
+		return props, nil
+	}
+
+	if schema == nil {
+		return nil, &ErrSchemaViolation{eventKind, version, ErrUnknownSchemaVersion}
+	}
+	if err := schema.Validate(eventObject); err != nil {
+		return nil, &ErrSchemaViolation{eventKind, schema.Version, err}
+	}
+
+	if version == "" && props != nil {
+		headers := make(EventHeaders, len(props.Headers)+1)
+		for k, v := range props.Headers {
+			headers[k] = v
+		}
+		headers[HeaderSchemaVersion] = schema.Version
+		props.Headers = headers
+	}
+	return props, nil
+}
+
+// validateIncoming returns the records event can be delivered to, dropping
+// the records that require validation in case the event is not valid.
+func (srv *Service) validateIncoming(de *decodedEvent, records []*handlerRecord) []*handlerRecord {
+	var validating bool
+	for _, record := range records {
+		validating = validating || record.validate
+	}
+	if !validating {
+		return records
+	}
+
+	event := de.event
+	version := event.Headers()[HeaderSchemaVersion]
+	srv.mu.Lock()
+	schema, ok := srv.lookupSchema(event.Kind(), version)
+	srv.mu.Unlock()
+	if !ok {
+		return records
+	}
+
+	var err error
+	if schema == nil {
+		err = ErrUnknownSchemaVersion
+	} else {
+		var obj interface{}
+		if obj, err = de.object(); err == nil {
+			err = schema.validate(obj)
+		}
+		version = schema.Version
+	}
+	if err == nil {
+		return records
+	}
+
+	srv.report(&ErrSchemaViolation{event.Kind(), version, err})
+
+	valid := make([]*handlerRecord, 0, len(records))
+	for _, record := range records {
+		if !record.validate {
+			valid = append(valid, record)
+		}
+	}
+	return valid
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrNotStruct            = errors.New("schema prototype is not a struct")
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+)
+
+type ErrInvalidField struct {
+	Field  string
+	Reason string
+}
+
+func (err *ErrInvalidField) Error() string {
+	return fmt.Sprintf("field %q: %v", err.Field, err.Reason)
+}
+
+type ErrSchemaViolation struct {
+	EventKind string
+	Version   string
+	Err       error
+}
+
+func (err *ErrSchemaViolation) Error() string {
+	return fmt.Sprintf("Event %v does not conform to schema version %q: %v",
+		err.EventKind, err.Version, err.Err)
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+type Base struct {
+	ID   int
+	Name string
+}
+
+type Optional struct {
+	Note string
+}
+
+type Build struct {
+	Base
+	*Optional
+	Name   string `codec:"name"`
+	Tags   []string
+	Output []byte `codec:",omitempty"`
+}
+
+func TestSchemaFromType_Embedded(t *testing.T) {
+	schema, err := pubsub.SchemaFromType(Build{}, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"ID":     true,
+		"Name":   true,
+		"name":   true,
+		"Note":   false,
+		"Tags":   true,
+		"Output": false,
+	}
+	if len(schema.Fields) != len(expected) {
+		t.Fatalf("expected %v fields, got %v", len(expected), len(schema.Fields))
+	}
+	for _, field := range schema.Fields {
+		required, ok := expected[field.Name]
+		if !ok {
+			t.Errorf("unexpected field %q", field.Name)
+			continue
+		}
+		if field.Required != required {
+			t.Errorf("field %q: expected required %v, got %v", field.Name, required, field.Required)
+		}
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := pubsub.SchemaFromType(Build{}, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.Strict = true
+
+	cases := []struct {
+		name  string
+		obj   interface{}
+		field string
+	}{
+		{
+			"struct",
+			&Build{Base: Base{ID: 1}, Tags: []string{}},
+			"",
+		},
+		{
+			"struct with the embedded pointer set",
+			Build{Optional: &Optional{Note: "x"}, Tags: []string{"a"}},
+			"",
+		},
+		{
+			"map",
+			map[string]interface{}{"ID": 1, "Name": "a", "name": "b", "Tags": []string{}},
+			"",
+		},
+		{
+			"decoded map",
+			map[interface{}]interface{}{"ID": int64(1), "Name": "a", "name": "b", "Tags": []interface{}{}},
+			"",
+		},
+		{
+			"missing field",
+			map[string]interface{}{"ID": 1, "Name": "a", "name": "b"},
+			"Tags",
+		},
+		{
+			"wrong type",
+			map[string]interface{}{"ID": "1", "Name": "a", "name": "b", "Tags": []int{}},
+			"ID",
+		},
+		{
+			"nil slice",
+			&Build{},
+			"Tags",
+		},
+		{
+			"bytes are not a list",
+			map[string]interface{}{"ID": 1, "Name": "a", "name": "b", "Tags": []byte("x")},
+			"Tags",
+		},
+		{
+			"unknown field",
+			map[string]interface{}{"ID": 1, "Name": "a", "name": "b", "Tags": []int{}, "x": 1},
+			"x",
+		},
+	}
+
+	for _, c := range cases {
+		err := schema.Validate(c.obj)
+		if c.field == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		if ferr, ok := err.(*pubsub.ErrInvalidField); !ok || ferr.Field != c.field {
+			t.Errorf("%v: expected ErrInvalidField for %q, got %v", c.name, c.field, err)
+		}
+	}
+}
+
+type Deploy struct {
+	Name string
+	At   time.Time
+}
+
+func TestSchemaFromType_SelfEncoding(t *testing.T) {
+	schema, err := pubsub.SchemaFromType(Deploy{}, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range schema.Fields {
+		if field.Name == "At" && field.Type != pubsub.FieldAny {
+			t.Errorf("expected field At to be of type any, got %v", field.Type)
+		}
+	}
+}
+
+func TestRegisterSchema_Publish(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	schema, err := pubsub.SchemaFromType(Deploy{}, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.RegisterSchema("deploy", schema)
+
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	rec := pubsubtest.NewRecorder()
+	_, err = srv.SubscribeWithOptions("deploy", rec.Handle, &pubsub.SubscriptionOptions{
+		Validate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The encoded time must pass the validation on the receiving side.
+	deploy := Deploy{"meeko", time.Now()}
+	if err := srv.Publish("deploy", deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.PublishWithHeaders("deploy", deploy, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Wait(2, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if len(errCh) != 0 {
+		t.Fatalf("unexpected error: %v", <-errCh)
+	}
+
+	// Publish must not make the event carry any properties.
+	published := transport.Published()
+	if headers := published[0].Headers(); headers != nil {
+		t.Errorf("expected no headers to be sent by Publish, got %v", headers)
+	}
+	if v := published[1].Headers()[pubsub.HeaderSchemaVersion]; v != "1" {
+		t.Errorf("expected schema version 1 to be sent by PublishWithHeaders, got %q", v)
+	}
+
+	if err := srv.Publish("deploy", map[string]interface{}{"Name": 1}); err == nil {
+		t.Error("expected the invalid object to be rejected")
+	} else if _, ok := err.(*pubsub.ErrSchemaViolation); !ok {
+		t.Errorf("expected ErrSchemaViolation, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// SeqDistance returns how many sequence numbers to is ahead of from, which is
+// negative when to is actually behind from.
+//
+// The transports use either 32-bit or 64-bit sequence numbers. 32-bit sequence
+// numbers wrap around, so when both the numbers fit into 32 bits, the distance
+// is computed using the serial number arithmetic as defined in RFC 1982.
+// 64-bit sequence numbers are not expected to wrap around ever.
+func SeqDistance(from, to EventSeqNum) int64 {
+	if from <= math.MaxUint32 && to <= math.MaxUint32 {
+		return int64(int32(uint32(to) - uint32(from)))
+	}
+	return int64(to - from)
+}
+
+// SeqOffset returns the sequence number n positions after seq, n being
+// negative meaning before, ref being any sequence number from the same
+// sequence. It is needed to tell whether seq is a 32-bit sequence number
+// that wraps around, see SeqDistance.
+func SeqOffset(seq EventSeqNum, n int64, ref EventSeqNum) EventSeqNum {
+	if seq <= math.MaxUint32 && ref <= math.MaxUint32 {
+		return EventSeqNum(uint32(int64(seq) + n))
+	}
+	return EventSeqNum(int64(seq) + n)
+}
+
+// nextSeq returns the sequence number following seq, see SeqOffset.
+func nextSeq(seq, ref EventSeqNum) EventSeqNum {
+	return SeqOffset(seq, 1, ref)
+}
+
+// prevSeq returns the sequence number preceding seq, see SeqOffset.
+func prevSeq(seq, ref EventSeqNum) EventSeqNum {
+	return SeqOffset(seq, -1, ref)
+}
+
+const (
+	// seqWindowSize is the number of the most recent sequence numbers
+	// remembered for every event kind to be able to tell duplicates
+	// from reordered events.
+	seqWindowSize = 64
+
+	// seqReorderWindow is how many sequence numbers an event can arrive late
+	// by to be considered reordered rather than lost. The events still missing
+	// once the window moves past them are reported as lost.
+	seqReorderWindow = 16
+)
+
+// DefaultGapTimeout is how long a missing event can arrive late by to be
+// considered reordered rather than lost unless set using SetGapTimeout.
+const DefaultGapTimeout = 5 * time.Second
+
+// SetGapTimeout sets how long a missing event can arrive late by to be
+// considered reordered rather than lost, zero meaning DefaultGapTimeout.
+//
+// The missing events are reported as lost once 16 events published after
+// them are received, or once timeout elapses, whichever comes first. Without
+// the timeout the events of the kinds published rarely would be reported
+// late or never at all, see ErrEventSequenceGap.
+func (srv *Service) SetGapTimeout(timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DefaultGapTimeout
+	}
+
+	srv.mu.Lock()
+	srv.gapTimeout = timeout
+	srv.mu.Unlock()
+}
+
+// seqWindow tracks the sequence numbers received for a single event kind.
+type seqWindow struct {
+	// The highest sequence number received or announced in a sequence table.
+	current EventSeqNum
+
+	// Bit i is set when current-i was received. Only the lowest known bits
+	// are valid, the sequence numbers before the window was created are not
+	// tracked.
+	received uint64
+	known    int64
+
+	// Bit i is set when current-i was reported lost on timeout while still
+	// in the reorder window, see expireMissing. missingSince is the time when
+	// some sequence number in the reorder window went missing, zero if none.
+	reported     uint64
+	missingSince time.Time
+
+	// The sequence number announced in a sequence table. The events up to
+	// this number can arrive after the table without being reordered.
+	synced    EventSeqNum
+	hasSynced bool
+}
+
+// seqVerdict tells what to do with an event.
+type seqVerdict byte
+
+const (
+	seqInOrder seqVerdict = iota
+	seqLate
+	seqReordered
+	seqDuplicate
+)
+
+// seqRange is a range of sequence numbers, from-to inclusive.
+type seqRange struct {
+	from, to EventSeqNum
+}
+
+func newSeqWindow(seq EventSeqNum) *seqWindow {
+	return &seqWindow{current: seq, received: 1, known: 1}
+}
+
+func newSyncedSeqWindow(seq EventSeqNum) *seqWindow {
+	return &seqWindow{current: seq, synced: seq, hasSynced: true}
+}
+
+// update registers seq as received and returns the verdict together with
+// the ranges of sequence numbers that are now considered lost, oldest first.
+func (w *seqWindow) update(seq EventSeqNum) (seqVerdict, []seqRange) {
+	d := SeqDistance(w.current, seq)
+
+	switch {
+	case d > 0:
+		lost := w.expire(seq, d)
+		w.current = seq
+		if d < seqWindowSize {
+			w.received = w.received<<uint(d) | 1
+			w.reported <<= uint(d)
+		} else {
+			w.received = 1
+			w.reported = 0
+		}
+		if w.known += d; w.known > seqWindowSize {
+			w.known = seqWindowSize
+		}
+		w.trackMissing()
+		return seqInOrder, lost
+
+	case -d < seqWindowSize:
+		bit := uint64(1) << uint(-d)
+		if w.received&bit != 0 {
+			return seqDuplicate, nil
+		}
+		w.received |= bit
+		w.reported &^= bit
+		w.trackMissing()
+		if w.hasSynced && SeqDistance(seq, w.synced) >= 0 {
+			return seqLate, nil
+		}
+		return seqReordered, nil
+
+	default:
+		// The sequence number is way behind, the sequence must have been
+		// restarted, e.g. because the broker was restarted.
+		*w = *newSeqWindow(seq)
+		return seqInOrder, nil
+	}
+}
+
+// expire returns the ranges of missing sequence numbers that the reorder
+// window moves past when seq, d positions ahead of the current one, arrives.
+// The sequence numbers reported lost already are skipped.
+func (w *seqWindow) expire(seq EventSeqNum, d int64) []seqRange {
+	var lost []seqRange
+
+	// The sequence numbers missing in the window, oldest first.
+	for i := w.openBits() - 1; i >= 0; i-- {
+		if i+d >= seqReorderWindow && w.isMissing(i) {
+			missing := SeqOffset(w.current, -i, seq)
+			lost = appendSeqRange(lost, missing, missing)
+		}
+	}
+
+	// The sequence numbers skipped by seq that are out of the window already.
+	if d-1 >= seqReorderWindow {
+		lost = appendSeqRange(lost, nextSeq(w.current, seq),
+			SeqOffset(seq, -seqReorderWindow, w.current))
+	}
+	return lost
+}
+
+// expireMissing returns the ranges of all the sequence numbers missing
+// in the reorder window and marks them as reported. It is used when
+// the events are not received in time, see SetGapTimeout.
+func (w *seqWindow) expireMissing() []seqRange {
+	var lost []seqRange
+	for i := w.openBits() - 1; i >= 0; i-- {
+		if w.isMissing(i) {
+			w.reported |= uint64(1) << uint(i)
+			missing := SeqOffset(w.current, -i, w.current)
+			lost = appendSeqRange(lost, missing, missing)
+		}
+	}
+	w.missingSince = time.Time{}
+	return lost
+}
+
+// openBits returns the number of the bits in the reorder window.
+func (w *seqWindow) openBits() int64 {
+	if w.known > seqReorderWindow {
+		return seqReorderWindow
+	}
+	return w.known
+}
+
+// isMissing returns true when current-i was neither received nor reported.
+func (w *seqWindow) isMissing(i int64) bool {
+	return (w.received|w.reported)&(uint64(1)<<uint(i)) == 0
+}
+
+// trackMissing updates missingSince after the window is updated.
+func (w *seqWindow) trackMissing() {
+	for i := w.openBits() - 1; i >= 0; i-- {
+		if w.isMissing(i) {
+			if w.missingSince.IsZero() {
+				w.missingSince = time.Now()
+			}
+			return
+		}
+	}
+	w.missingSince = time.Time{}
+}
+
+// appendSeqRange appends from-to to lost, merging it with the last range
+// in case they are adjacent.
+func appendSeqRange(lost []seqRange, from, to EventSeqNum) []seqRange {
+	if n := len(lost); n != 0 && SeqDistance(lost[n-1].to, from) == 1 {
+		lost[n-1].to = to
+		return lost
+	}
+	return append(lost, seqRange{from, to})
+}
+
+// markReceived marks seq as received without updating the current sequence
+// number. It is used for the events delivered during gap recovery.
+func (w *seqWindow) markReceived(seq EventSeqNum) {
+	if d := SeqDistance(seq, w.current); d >= 0 && d < seqWindowSize {
+		w.received |= uint64(1) << uint(d)
+	}
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrEventDuplicate struct {
+	EventKind string
+	Seq       EventSeqNum
+}
+
+func (err *ErrEventDuplicate) Error() string {
+	return fmt.Sprintf("Duplicate event %v #%v dropped", err.EventKind, err.Seq)
+}
+
+type ErrEventReordered struct {
+	EventKind  string
+	Seq        EventSeqNum
+	CurrentSeq EventSeqNum
+}
+
+func (err *ErrEventReordered) Error() string {
+	return fmt.Sprintf("Event %v #%v received after #%v", err.EventKind, err.Seq, err.CurrentSeq)
+}
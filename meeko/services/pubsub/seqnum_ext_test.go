@@ -0,0 +1,215 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"math"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+func TestService_ReorderedEvents(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	rec := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("a", rec.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.ReorderNext("a", 3)
+	for i := 0; i < 5; i++ {
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Wait(5, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	barrier(t, srv)
+
+	// Events 2 and 1 are received after 3, no gap is reported.
+	if len(errCh) != 2 {
+		t.Fatalf("expected 2 errors, got %v", len(errCh))
+	}
+	for i := 0; i < 2; i++ {
+		if err, ok := (<-errCh).(*pubsub.ErrEventReordered); !ok {
+			t.Errorf("expected ErrEventReordered, got %v", err)
+		}
+	}
+}
+
+func TestService_SeqWrapAround(t *testing.T) {
+	cases := []struct {
+		seqNum64 bool
+		expected []pubsub.EventSeqNum
+	}{
+		{false, []pubsub.EventSeqNum{math.MaxUint32, 0, 1}},
+		{true, []pubsub.EventSeqNum{math.MaxUint32, math.MaxUint32 + 1, math.MaxUint32 + 2}},
+	}
+
+	for _, c := range cases {
+		srv, transport := newTestService(t)
+		errCh := make(chan error, 10)
+		srv.Monitor(errCh)
+		transport.SetSeqNum64(c.seqNum64)
+		transport.SetSeq("a", math.MaxUint32-1)
+
+		rec := pubsubtest.NewRecorder()
+		subscribeOrdered(t, srv, "a", rec)
+
+		for i := 0; i < 3; i++ {
+			if err := srv.Publish("a", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.Wait(3, testTimeout); err != nil {
+			t.Fatal(err)
+		}
+		barrier(t, srv)
+
+		for i, event := range rec.Events() {
+			if event.Seq() != c.expected[i] {
+				t.Errorf("64-bit %v: expected seq %v, got %v", c.seqNum64, c.expected[i], event.Seq())
+			}
+		}
+		if len(errCh) != 0 {
+			t.Errorf("64-bit %v: unexpected error: %v", c.seqNum64, <-errCh)
+		}
+		srv.Close()
+	}
+}
+
+func TestService_GapReportedOnceExpired(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	rec := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("a", rec.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// Event 2 is lost, but it is only reported once the events up to 18
+	// have been received.
+	for i := 1; i <= 17; i++ {
+		if i == 2 {
+			transport.DropNext("a", 1)
+		}
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Wait(16, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	barrier(t, srv)
+	if len(errCh) != 0 {
+		t.Fatalf("unexpected error: %v", <-errCh)
+	}
+
+	if err := srv.Publish("a", 18); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		gap, ok := err.(*pubsub.ErrEventSequenceGap)
+		if !ok {
+			t.Fatalf("expected ErrEventSequenceGap, got %v", err)
+		}
+		if gap.ExpectedSeq != 2 || gap.ReceivedSeq != 3 {
+			t.Errorf("unexpected gap: %v", gap)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the gap to be reported")
+	}
+
+	if stats := srv.Stats().Kinds["a"]; stats.Gaps != 1 || stats.MissedEvents != 1 {
+		t.Errorf("expected 1 gap of 1 event in the stats, got %v and %v",
+			stats.Gaps, stats.MissedEvents)
+	}
+}
+
+func TestService_GapReportedOnTimeout(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+	srv.SetGapTimeout(50 * time.Millisecond)
+
+	rec := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("a", rec.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// Event 2 is lost, there are not enough events to report it otherwise.
+	for i := 1; i <= 3; i++ {
+		if i == 2 {
+			transport.DropNext("a", 1)
+		}
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		gap, ok := err.(*pubsub.ErrEventSequenceGap)
+		if !ok {
+			t.Fatalf("expected ErrEventSequenceGap, got %v", err)
+		}
+		if gap.ExpectedSeq != 2 || gap.ReceivedSeq != 3 {
+			t.Errorf("unexpected gap: %v", gap)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the gap to be reported")
+	}
+}
+
+func TestService_GapRecoveredOnTimeout(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+	srv.SetGapTimeout(50 * time.Millisecond)
+
+	var (
+		calledCh  = make(chan struct{}, 1)
+		releaseCh = make(chan struct{})
+	)
+	close(releaseCh)
+	srv.EnableGapRecovery(publishedFetcher(transport, calledCh, releaseCh), testTimeout)
+
+	rec := pubsubtest.NewRecorder()
+	subscribeOrdered(t, srv, "a", rec)
+
+	for i := 1; i <= 3; i++ {
+		if i == 2 {
+			transport.DropNext("a", 1)
+		}
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Wait(3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := recordedSeqs(rec); !equalInts(seqs, []int{1, 3, 2}) {
+		t.Errorf("expected %v, got %v", []int{1, 3, 2}, seqs)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
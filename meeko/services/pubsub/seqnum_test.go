@@ -0,0 +1,188 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSeqDistance(t *testing.T) {
+	cases := []struct {
+		from, to EventSeqNum
+		distance int64
+	}{
+		{1, 2, 1},
+		{2, 1, -1},
+		{5, 5, 0},
+		{math.MaxUint32, 0, 1},
+		{0, math.MaxUint32, -1},
+		{math.MaxUint32 - 1, 1, 3},
+		{math.MaxUint32, math.MaxUint32 + 1, 1},
+		{1 << 40, 1<<40 + 5, 5},
+		{1<<40 + 5, 1 << 40, -5},
+	}
+
+	for _, c := range cases {
+		if d := SeqDistance(c.from, c.to); d != c.distance {
+			t.Errorf("SeqDistance(%v, %v): expected %v, got %v", c.from, c.to, c.distance, d)
+		}
+	}
+}
+
+func TestSeqOffset(t *testing.T) {
+	cases := []struct {
+		seq, ref EventSeqNum
+		n        int64
+		expected EventSeqNum
+	}{
+		{1, 1, 1, 2},
+		{math.MaxUint32, 1, 1, 0},
+		{0, 1, -1, math.MaxUint32},
+		{2, 1, -5, math.MaxUint32 - 2},
+		{math.MaxUint32, 1 << 40, 1, 1 << 32},
+		{1 << 32, 1 << 40, -1, math.MaxUint32},
+		{0, 1 << 40, -1, math.MaxUint64},
+		{1 << 40, 1<<40 + 2, 1, 1<<40 + 1},
+	}
+
+	for _, c := range cases {
+		if seq := SeqOffset(c.seq, c.n, c.ref); seq != c.expected {
+			t.Errorf("SeqOffset(%v, %v, %v): expected %v, got %v",
+				c.seq, c.n, c.ref, c.expected, seq)
+		}
+	}
+}
+
+func TestSeqWindow(t *testing.T) {
+	type step struct {
+		seq     EventSeqNum
+		verdict seqVerdict
+		lost    []seqRange
+	}
+
+	cases := []struct {
+		name   string
+		window *seqWindow
+		steps  []step
+	}{
+		{
+			"in order",
+			newSeqWindow(1),
+			[]step{
+				{2, seqInOrder, nil},
+				{3, seqInOrder, nil},
+			},
+		},
+		{
+			"duplicate",
+			newSeqWindow(1),
+			[]step{
+				{2, seqInOrder, nil},
+				{2, seqDuplicate, nil},
+				{1, seqDuplicate, nil},
+			},
+		},
+		{
+			"reordered within the window",
+			newSeqWindow(1),
+			[]step{
+				{3, seqInOrder, nil},
+				{2, seqReordered, nil},
+				{4, seqInOrder, nil},
+				{20, seqInOrder, nil},
+			},
+		},
+		{
+			"gap expires",
+			newSeqWindow(1),
+			[]step{
+				{3, seqInOrder, nil},
+				{17, seqInOrder, nil},
+				{18, seqInOrder, []seqRange{{2, 2}}},
+				{2, seqReordered, nil},
+			},
+		},
+		{
+			"gap larger than the window",
+			newSeqWindow(1),
+			[]step{
+				{40, seqInOrder, []seqRange{{2, 24}}},
+				{41, seqInOrder, []seqRange{{25, 25}}},
+				{39, seqReordered, nil},
+				{60, seqInOrder, []seqRange{{26, 38}, {42, 44}}},
+			},
+		},
+		{
+			"wrap around",
+			newSeqWindow(math.MaxUint32),
+			[]step{
+				{1, seqInOrder, nil},
+				{17, seqInOrder, []seqRange{{0, 0}}},
+			},
+		},
+		{
+			"restart",
+			newSeqWindow(1000),
+			[]step{
+				{1, seqInOrder, nil},
+				{2, seqInOrder, nil},
+			},
+		},
+		{
+			"synced",
+			newSyncedSeqWindow(10),
+			[]step{
+				{12, seqInOrder, nil},
+				{11, seqReordered, nil},
+				{9, seqLate, nil},
+				{30, seqInOrder, []seqRange{{13, 14}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		for i, s := range c.steps {
+			verdict, lost := c.window.update(s.seq)
+			if verdict != s.verdict {
+				t.Errorf("%v, step %v: expected verdict %v, got %v", c.name, i, s.verdict, verdict)
+			}
+			if !reflect.DeepEqual(lost, s.lost) {
+				t.Errorf("%v, step %v: expected lost %v, got %v", c.name, i, s.lost, lost)
+			}
+		}
+	}
+}
+
+func TestSeqWindow_ExpireMissing(t *testing.T) {
+	w := newSeqWindow(1)
+	if _, lost := w.update(4); lost != nil || w.missingSince.IsZero() {
+		t.Fatalf("expected events 2 and 3 to be missing, got lost %v", lost)
+	}
+
+	if lost := w.expireMissing(); !reflect.DeepEqual(lost, []seqRange{{2, 3}}) {
+		t.Errorf("expected lost %v, got %v", []seqRange{{2, 3}}, lost)
+	}
+	if !w.missingSince.IsZero() || w.expireMissing() != nil {
+		t.Error("expected no events to be missing after expiry")
+	}
+
+	// The events reported lost are delivered when they arrive late after all,
+	// but they are not reported again once the reorder window moves past them.
+	if verdict, _ := w.update(3); verdict != seqReordered {
+		t.Errorf("expected the late event to be reordered, got verdict %v", verdict)
+	}
+	if verdict, _ := w.update(3); verdict != seqDuplicate {
+		t.Errorf("expected a duplicate, got verdict %v", verdict)
+	}
+	for seq := EventSeqNum(5); seq <= 30; seq++ {
+		if _, lost := w.update(seq); lost != nil {
+			t.Fatalf("seq %v: expected nothing lost, got %v", seq, lost)
+		}
+	}
+}
@@ -7,10 +7,12 @@ package pubsub
 
 import (
 	// Stdlib
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	// Meeko
 	"github.com/meeko/go-meeko/meeko/services"
@@ -24,10 +26,18 @@ import (
 // Service
 //------------------------------------------------------------------------------
 
-type (
-	EventHandler  func(event Event)
-	EventListener uint16
-)
+type EventHandler func(event Event)
+
+// Listener represents an event handler registered with the service.
+type Listener struct {
+	srv *Service
+	id  uint64
+}
+
+// Close removes the listener, see Service.RemoveListener.
+func (listener *Listener) Close() error {
+	return listener.srv.RemoveListener(listener)
+}
 
 // Service represents a PubSub service instance.
 //
@@ -37,19 +47,60 @@ type Service struct {
 	transport Transport
 
 	// For processing of incoming events.
-	trie                *patricia.Trie
-	trieItemsByListener map[EventListener]*trieItem
-	nextListener        EventListener
-	seqNumsByEventKind  map[string]EventSeqNum
+	trie              *patricia.Trie
+	recordsByListener map[*Listener]*handlerRecord
+	lastListenerId    uint64
+	seqWindowsByKind  map[string]*seqWindow
+
+	// For reporting the missing events that are not received in time,
+	// see SetGapTimeout.
+	gapTimeout   time.Duration
+	gapTimers    map[string]*time.Timer
+	gapExpiredCh chan string
+
+	// The number of listeners for every kind prefix the transport is subscribed
+	// for and the sequence tables requested by subscribing, see SubscribeSync.
+	subscriptions    map[string]int
+	seqTableRequests []*seqTableRequest
+	seqTableTimeout  time.Duration
 
 	// monitorCh is defined by the user.
 	monitorCh chan<- error
 
-	// For clean termination process.
+	// For collecting the statistics, see Stats.
+	stats *statsCollector
+
+	// For fetching the missed events. recoveries is only accessed from within
+	// the service loop.
+	fetcher         EventFetcher
+	recoveryTimeout time.Duration
+	recoveries      map[string]*gapRecovery
+	recoveredCh     chan *gapRecovery
+
+	// For observing the events published, see OnPublished.
+	observers []*publishObserver
+	ackCh     <-chan *PublishedEvent
+
+	// Event schemas registered using RegisterSchema.
+	schemas *patricia.Trie
+
+	// For publishing through a local queue, see EnableOutbox.
+	outbox *outbox
+
+	// For limiting the number of running handlers.
+	limits          *HandlerLimits
+	runningHandlers int
+	pending         []*pendingEvent
+	waitingRecords  []*handlerRecord
+	blocked         []*blockedEvent
+
+	// For clean termination process. terminatingCh is closed as soon as
+	// the termination starts, closedCh once the service is terminated.
 	numRunningHandlers int32
-	handlerReturnedCh  chan bool
+	handlerReturnedCh  chan *handlerRecord
 	abortCh            chan error
 	err                error
+	terminatingCh      chan struct{}
 	closedCh           chan struct{}
 
 	// For synchronizatin where user method calls could clash with internal
@@ -79,14 +130,27 @@ func NewService(factory func() (Transport, error)) (srv *Service, err error) {
 	}
 
 	srv = &Service{
-		transport:           transport,
-		trie:                patricia.NewTrie(),
-		trieItemsByListener: make(map[EventListener]*trieItem),
-		seqNumsByEventKind:  make(map[string]EventSeqNum),
-		handlerReturnedCh:   make(chan bool),
-		abortCh:             make(chan error),
-		closedCh:            make(chan struct{}),
-		mu:                  new(sync.Mutex),
+		transport:         transport,
+		trie:              patricia.NewTrie(),
+		recordsByListener: make(map[*Listener]*handlerRecord),
+		seqWindowsByKind:  make(map[string]*seqWindow),
+		gapTimeout:        DefaultGapTimeout,
+		gapTimers:         make(map[string]*time.Timer),
+		gapExpiredCh:      make(chan string),
+		subscriptions:     make(map[string]int),
+		seqTableTimeout:   DefaultSeqTableTimeout,
+		stats:             newStatsCollector(),
+		recoveries:        make(map[string]*gapRecovery),
+		recoveredCh:       make(chan *gapRecovery),
+		handlerReturnedCh: make(chan *handlerRecord),
+		abortCh:           make(chan error),
+		terminatingCh:     make(chan struct{}),
+		closedCh:          make(chan struct{}),
+		mu:                new(sync.Mutex),
+	}
+
+	if t, ok := transport.(AckingTransport); ok {
+		srv.ackCh = t.AckChan()
 	}
 
 	go srv.loop()
@@ -94,10 +158,48 @@ func NewService(factory func() (Transport, error)) (srv *Service, err error) {
 }
 
 // Publish sends eventObject to Meeko and thus publishes it for other apps.
+// No event properties are attached, see PublishWithHeaders.
 //
 // eventObject must be marshallable by github.com/ugorji/go/codec.
 func (srv *Service) Publish(eventKind string, eventObject interface{}) error {
-	if err := srv.transport.Publish(eventKind, eventObject); err != nil {
+	return srv.publish(eventKind, eventObject, nil)
+}
+
+// PublishWithHeaders works like Publish, but it also attaches headers to
+// the event. The event ID and timestamp are generated automatically.
+//
+// The event properties are sent in an extra message frame, which subscribers
+// using a transport that does not support the properties fail to decode.
+// Use Publish to reach these subscribers.
+func (srv *Service) PublishWithHeaders(eventKind string, eventObject interface{},
+	headers EventHeaders) error {
+
+	id, err := newEventId()
+	if err != nil {
+		return err
+	}
+
+	return srv.publish(eventKind, eventObject, &EventProps{
+		Id:        id,
+		Timestamp: time.Now(),
+		Headers:   headers,
+	})
+}
+
+func (srv *Service) publish(eventKind string, eventObject interface{}, props *EventProps) error {
+	props, err := srv.validateOutgoing(eventKind, eventObject, props)
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	outbox := srv.outbox
+	srv.mu.Unlock()
+	if outbox != nil {
+		return outbox.append(eventKind, eventObject, props)
+	}
+
+	if err := srv.sendEvent(eventKind, eventObject, props); err != nil {
 		return srv.abort(err)
 	}
 	return nil
@@ -109,48 +211,65 @@ func (srv *Service) Publish(eventKind string, eventObject interface{}) error {
 // to subscribe the same handler for the same event prefix multiple times
 // because it will work.
 //
-// EventListener returned by this method can be later used to remove the handler
-// by calling RemoveListener method.
-func (srv *Service) Subscribe(eventKindPrefix string, handler EventHandler) (EventListener, error) {
-	srv.mu.Lock()
-	defer srv.mu.Unlock()
+// Listener returned by this method can be later used to remove the handler
+// by calling RemoveListener method or Listener.Close.
+func (srv *Service) Subscribe(eventKindPrefix string, handler EventHandler) (*Listener, error) {
+	return srv.SubscribeWithOptions(eventKindPrefix, handler, nil)
+}
 
-	listener, err := srv.registerHandler(eventKindPrefix, handler)
-	if err != nil {
-		return 0, err
+// SubscribeWithOptions works like Subscribe, but opts can be used to modify
+// the way the events are delivered to handler. nil opts means the defaults.
+func (srv *Service) SubscribeWithOptions(eventKindPrefix string, handler EventHandler,
+	opts *SubscriptionOptions) (*Listener, error) {
+
+	return srv.subscribe(eventKindPrefix, nil, handler, opts)
+}
+
+// subscribe registers handler for events starting with eventKindPrefix
+// and matching pattern, which can be nil.
+func (srv *Service) subscribe(eventKindPrefix string, pattern *kindPattern,
+	handler EventHandler, opts *SubscriptionOptions) (*Listener, error) {
+
+	if opts == nil {
+		opts = &SubscriptionOptions{}
 	}
 
-	if err := srv.transport.Subscribe(eventKindPrefix); err != nil {
-		return 0, srv.abort(err)
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	listener := srv.registerHandler(eventKindPrefix, pattern, handler, opts)
+	if err := srv.subscribePrefix(eventKindPrefix); err != nil {
+		return nil, err
 	}
 
 	return listener, nil
 }
 
 // Unsubscribe cancels all subscriptions with the given event kind prefix and
-// removes all assigned event handlers.
+// removes all assigned event handlers. That includes the subscriptions for
+// the kind prefixes starting with eventKindPrefix.
 func (srv *Service) Unsubscribe(eventKindPrefix string) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	srv.unregisterPrefix(eventKindPrefix)
-	if err := srv.transport.Unsubscribe(eventKindPrefix); err != nil {
-		return srv.abort(err)
+	for _, prefix := range srv.unregisterPrefix(eventKindPrefix) {
+		delete(srv.subscriptions, prefix)
+		if err := srv.transport.Unsubscribe(prefix); err != nil {
+			return srv.abort(err)
+		}
 	}
-
 	return nil
 }
 
-// RemoveListener unregisters listener and possibly unsubscribes from the kind
-// prefix that listener was listening for. That may fail and return an error.
-func (srv *Service) RemoveListener(listener EventListener) error {
+// RemoveListener unregisters listener and unsubscribes from the kind prefix
+// that listener was listening for in case it was the last listener for
+// the prefix. That may fail and return an error.
+func (srv *Service) RemoveListener(listener *Listener) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
 	if prefix, ok := srv.unregisterListener(listener); ok {
-		if err := srv.transport.Unsubscribe(prefix); err != nil {
-			return srv.abort(err)
-		}
+		return srv.unsubscribePrefix(prefix)
 	}
 
 	return nil
@@ -161,6 +280,20 @@ func (srv *Service) RemoveListener(listener EventListener) error {
 //
 // Possible error types that can be received on this channel:
 //   - *ErrEventSequenceGap - some events were missed due to transport overload
+//   - *ErrEventReordered - an event was received after an event published later
+//   - *ErrEventDuplicate - an event was received twice and it was dropped
+//   - *ErrEventDecode - an event could not be decoded by SubscribeTyped or
+//     for a subscription filter, see SubscriptionOptions.Filter
+//   - *ErrEventDropped - an event was dropped because of handler limits
+//   - *ErrGapRecovery - missed events could not be fetched, see EnableGapRecovery
+//   - *ErrEventExpired - an event expired in the outbox, see EnableOutbox
+//   - *ErrOutboxSend - an event could not be sent from the outbox
+//   - *ErrSchemaViolation - an event did not pass validation, see RegisterSchema
+//   - *ErrHandlerFailed - a handler gave up on an event, see SubscribeFallible
+//   - *ErrDeadLetter - a dead letter could not be published
+//
+// The service never blocks on errChan, so it should be buffered. The errors
+// that do not fit into errChan are dropped and counted, see Stats.
 func (srv *Service) Monitor(errChan chan<- error) {
 	srv.mu.Lock()
 	srv.monitorCh = errChan
@@ -186,6 +319,26 @@ func (srv *Service) Wait() error {
 	return srv.err
 }
 
+// report sends err to the monitoring channel if there is any.
+func (srv *Service) report(err error) {
+	srv.mu.Lock()
+	srv.monitor(err)
+	srv.mu.Unlock()
+}
+
+// monitor does the same as report, but srv.mu must be already locked.
+// It never blocks, the errors that do not fit into the channel are dropped.
+func (srv *Service) monitor(err error) {
+	if srv.monitorCh == nil {
+		return
+	}
+	select {
+	case srv.monitorCh <- err:
+	default:
+		srv.stats.monitorOverflowed()
+	}
+}
+
 func (srv *Service) loop() {
 	for {
 		select {
@@ -202,8 +355,20 @@ func (srv *Service) loop() {
 			srv.abort(err)
 
 		// For receiving notifications about returning event handlers.
-		case <-srv.handlerReturnedCh:
-			atomic.AddInt32(&srv.numRunningHandlers, -1)
+		case record := <-srv.handlerReturnedCh:
+			srv.handlerReturned(record)
+
+		// For receiving the event kinds with missing events not received in time.
+		case kind := <-srv.gapExpiredCh:
+			srv.expireGaps(kind)
+
+		// For receiving the events fetched to fill sequence number gaps.
+		case recovery := <-srv.recoveredCh:
+			srv.finishRecovery(recovery)
+
+		// For receiving the sequence numbers of the events published.
+		case event := <-srv.ackCh:
+			srv.notifyObservers(event)
 
 		// Receive on abortCh means that we want to terminate, so just wait
 		// for all the handlers to terminate and close closedCh.
@@ -211,13 +376,16 @@ func (srv *Service) loop() {
 			if srv.err == nil {
 				srv.err = err
 			}
+			close(srv.terminatingCh)
+			srv.stopGapTimers()
+			srv.dropPending()
 			for {
 				running := atomic.LoadInt32(&srv.numRunningHandlers)
 				if running == 0 {
 					close(srv.closedCh)
 					return
 				}
-				<-srv.handlerReturnedCh
+				srv.handlerReturned(<-srv.handlerReturnedCh)
 			}
 		}
 	}
@@ -241,158 +409,384 @@ type trieItem struct {
 }
 
 type handlerRecord struct {
-	listener EventListener
+	listener *Listener
+	pattern  *kindPattern
 	handler  EventHandler
+	queues   *eventQueues
+	limits   *HandlerLimits
+	sink     *eventSink
+	validate bool
+	filter   *Filter
+
+	// The trie item holding the record and its index in item.records,
+	// so that the record can be removed in constant time.
+	item  *trieItem
+	index int
+
+	// Only accessed from within the service loop.
+	running int
+	pending []*pendingEvent
 }
 
 // registerHandler inserts handler into the handlers tree and returns
-// EventListener identifying the handler.
-func (srv *Service) registerHandler(kindPrefix string, handler EventHandler) (EventListener, error) {
-	listener, err := srv.nextListenerHandle()
-	if err != nil {
-		return 0, err
-	}
+// Listener identifying the handler.
+func (srv *Service) registerHandler(kindPrefix string, pattern *kindPattern,
+	handler EventHandler, opts *SubscriptionOptions) *Listener {
+
+	srv.lastListenerId++
+	listener := &Listener{srv, srv.lastListenerId}
 
 	// Try to insert a new node.
 	key := patricia.Prefix(kindPrefix)
-	record := &handlerRecord{listener, handler}
+	record := &handlerRecord{
+		listener: listener,
+		pattern:  pattern,
+		handler:  handler,
+		queues:   newEventQueues(opts.Delivery),
+		limits:   opts.Limits,
+		sink:     opts.sink,
+		validate: opts.Validate,
+		filter:   opts.Filter,
+	}
 	if item := srv.trie.Get(key); item == nil {
 		// If kindPrefix is not registered yet, update the item index.
-		itm := &trieItem{
+		record.item = &trieItem{
 			kindPrefix: kindPrefix,
 			records:    []*handlerRecord{record},
 		}
-		srv.trie.Insert(key, itm)
-		srv.trieItemsByListener[listener] = itm
-		return listener, nil
+		srv.trie.Insert(key, record.item)
 	} else {
 		// If the relevant kind prefix node exists, append the record.
-		itm := item.(*trieItem)
-		itm.records = append(itm.records, record)
-		return listener, nil
+		record.item = item.(*trieItem)
+		record.index = len(record.item.records)
+		record.item.records = append(record.item.records, record)
 	}
+	srv.recordsByListener[listener] = record
+	return listener
 }
 
-// unregisterListener removes listener and returns a string identifying the
-// subscription that is to be canceled. Empty string means no cancelation.
-func (srv *Service) unregisterListener(listener EventListener) (prefix string, ok bool) {
-	item, ok := srv.trieItemsByListener[listener]
+// unregisterListener removes listener and returns the kind prefix it was
+// listening for. ok is false when listener is not registered.
+func (srv *Service) unregisterListener(listener *Listener) (prefix string, ok bool) {
+	record, ok := srv.recordsByListener[listener]
 	if !ok {
 		return "", false
 	}
+	delete(srv.recordsByListener, listener)
 
+	item := record.item
 	if len(item.records) == 1 {
 		if ok := srv.trie.Delete(patricia.Prefix(item.kindPrefix)); !ok {
 			panic("Kind prefix unexpectedly not found in the kinds trie")
 		}
 		return item.kindPrefix, true
-	} else {
-		for i, record := range item.records {
-			if record.listener == listener {
-				item.records = append(item.records[:i], item.records[i+1:]...)
-				return item.kindPrefix, true
-			}
-		}
 	}
 
-	panic("Listener not found in the kinds trie")
+	// Move the last record in place of the one being removed.
+	last := item.records[len(item.records)-1]
+	item.records[record.index] = last
+	last.index = record.index
+	item.records[len(item.records)-1] = nil
+	item.records = item.records[:len(item.records)-1]
+	return item.kindPrefix, true
 }
 
-// unregisterPrefix removes all the listeners matching kindPrefix.
-func (srv *Service) unregisterPrefix(kindPrefix string) {
-	// Free trieItemsByListener.
+// unregisterPrefix removes all the listeners matching kindPrefix and returns
+// the kind prefixes removed. The channels of the channel listeners removed
+// are closed.
+func (srv *Service) unregisterPrefix(kindPrefix string) []string {
+	// Free recordsByListener.
+	var prefixes []string
 	srv.trie.VisitSubtree(
 		patricia.Prefix(kindPrefix),
 		func(prefix patricia.Prefix, item patricia.Item) error {
-			for _, record := range item.(*trieItem).records {
-				delete(srv.trieItemsByListener, record.listener)
+			itm := item.(*trieItem)
+			for _, record := range itm.records {
+				delete(srv.recordsByListener, record.listener)
+				if record.sink != nil {
+					record.sink.close()
+				}
 			}
+			prefixes = append(prefixes, itm.kindPrefix)
 			return nil
 		})
 
 	// Drop part of the kinds trie.
 	srv.trie.DeleteSubtree(patricia.Prefix(kindPrefix))
+	return prefixes
 }
 
-func (srv *Service) nextListenerHandle() (EventListener, error) {
-	begin := srv.nextListener - 1
-	for next := srv.nextListener; next != begin; next++ {
-		if _, ok := srv.trieItemsByListener[next]; !ok {
-			srv.nextListener = next + 1
-			return next, nil
-		}
+// subscribePrefix registers another listener for kindPrefix and subscribes
+// the transport for the prefix in case it is the first one. The transport is
+// subscribed for every prefix once no matter how many listeners there are.
+// srv.mu must be locked.
+func (srv *Service) subscribePrefix(kindPrefix string) error {
+	srv.subscriptions[kindPrefix]++
+	if srv.subscriptions[kindPrefix] != 1 {
+		return nil
 	}
-	return 0, ErrListenerHandlesDepleted
+
+	if err := srv.transport.Subscribe(kindPrefix); err != nil {
+		return srv.abort(err)
+	}
+	srv.expectSeqTable(kindPrefix)
+	return nil
 }
 
+// unsubscribePrefix unregisters a listener for kindPrefix and unsubscribes
+// the transport in case it was the last one. srv.mu must be locked.
+func (srv *Service) unsubscribePrefix(kindPrefix string) error {
+	srv.subscriptions[kindPrefix]--
+	if srv.subscriptions[kindPrefix] != 0 {
+		return nil
+	}
+
+	delete(srv.subscriptions, kindPrefix)
+	if err := srv.transport.Unsubscribe(kindPrefix); err != nil {
+		return srv.abort(err)
+	}
+	return nil
+}
+
+// updateEventSeqNums sets the initial sequence numbers for the event kinds
+// that no event has been received for yet. The events received later reveal
+// whether any events were lost.
 func (srv *Service) updateEventSeqNums(seqTable EventSeqTable) {
 	srv.mu.Lock()
 	for k, v := range seqTable {
+		if _, ok := srv.seqWindowsByKind[k]; ok {
+			continue
+		}
 		log.Debugf("zmq3<PubSub>: Setting event sequence number for %v to %v", k, v)
-		srv.updateSeqNum(k, v)
+		srv.seqWindowsByKind[k] = newSyncedSeqWindow(v)
 	}
+	srv.seqTableReceived(seqTable)
 	srv.mu.Unlock()
 }
 
-func (srv *Service) updateSeqNum(eventKind string, seqNum EventSeqNum) {
-	current, ok := srv.seqNumsByEventKind[eventKind]
+// updateSeqNum registers the sequence number of a received event and returns
+// an error in case the event is not the one expected, together with the gaps
+// of events that are now considered lost. deliver is false when the event is
+// a duplicate and it should be dropped.
+//
+// No need to srv.mu.Lock() here, the lock is already being held by
+// the calling function (it's invokeHandlers).
+func (srv *Service) updateSeqNum(eventKind string,
+	seqNum EventSeqNum) (gaps []*ErrEventSequenceGap, err error, deliver bool) {
 
-	// Update the current sequence number.
-	srv.seqNumsByEventKind[eventKind] = seqNum
+	window, ok := srv.seqWindowsByKind[eventKind]
 
 	// Return if there was no sequence number before. That means that an event
 	// was received before the reply to the relevant event sequence table request.
 	if !ok {
+		srv.seqWindowsByKind[eventKind] = newSeqWindow(seqNum)
+		return nil, nil, true
+	}
+
+	current := window.current
+	verdict, lost := window.update(seqNum)
+	gaps = newGaps(eventKind, lost)
+	srv.watchGaps(eventKind, window)
+
+	switch verdict {
+	case seqReordered:
+		return gaps, &ErrEventReordered{
+			EventKind:  eventKind,
+			Seq:        seqNum,
+			CurrentSeq: current,
+		}, true
+	case seqDuplicate:
+		return gaps, &ErrEventDuplicate{
+			EventKind: eventKind,
+			Seq:       seqNum,
+		}, false
+	default:
+		return gaps, nil, true
+	}
+}
+
+// newGaps turns the ranges of the sequence numbers lost into errors.
+func newGaps(eventKind string, lost []seqRange) []*ErrEventSequenceGap {
+	var gaps []*ErrEventSequenceGap
+	for _, r := range lost {
+		gaps = append(gaps, &ErrEventSequenceGap{
+			EventKind:   eventKind,
+			ExpectedSeq: r.from,
+			ReceivedSeq: nextSeq(r.to, r.from),
+		})
+	}
+	return gaps
+}
+
+// watchGaps makes sure the missing events of eventKind are reported once
+// they are not received in time, see SetGapTimeout. srv.mu must be locked.
+func (srv *Service) watchGaps(eventKind string, window *seqWindow) {
+	if window.missingSince.IsZero() {
 		return
 	}
+	if _, ok := srv.gapTimers[eventKind]; ok {
+		return
+	}
+
+	wait := srv.gapTimeout - time.Since(window.missingSince)
+	srv.gapTimers[eventKind] = time.AfterFunc(wait, func() {
+		select {
+		case srv.gapExpiredCh <- eventKind:
+		case <-srv.closedCh:
+		}
+	})
+}
 
-	// Sequence number matches, return.
-	if seqNum == current+1 {
+// stopGapTimers stops the timers set by watchGaps.
+func (srv *Service) stopGapTimers() {
+	srv.mu.Lock()
+	for kind, timer := range srv.gapTimers {
+		timer.Stop()
+		delete(srv.gapTimers, kind)
+	}
+	srv.mu.Unlock()
+}
+
+// expireGaps reports the events of eventKind that are still missing once
+// the gap timeout elapsed, or fetches them in case gap recovery is enabled.
+// It is only called from within the service loop.
+func (srv *Service) expireGaps(eventKind string) {
+	srv.mu.Lock()
+	delete(srv.gapTimers, eventKind)
+
+	// The missing events may have been received in the meantime.
+	window, ok := srv.seqWindowsByKind[eventKind]
+	if !ok || window.missingSince.IsZero() {
+		srv.mu.Unlock()
+		return
+	}
+	if time.Since(window.missingSince) < srv.gapTimeout {
+		srv.watchGaps(eventKind, window)
+		srv.mu.Unlock()
 		return
 	}
 
-	// Otherwise there were some events lost.
-	//
-	// No need to srv.mu.Lock() here, the lock is already being held by
-	// the calling function (it's updateEventSeqNums or invokeHandlers).
-	if srv.monitorCh != nil {
-		srv.monitorCh <- &ErrEventSequenceGap{
-			EventKind:   eventKind,
-			ExpectedSeq: current + 1,
-			ReceivedSeq: seqNum,
+	gaps := newGaps(eventKind, window.expireMissing())
+	for _, gap := range gaps {
+		srv.stats.gapDetected(gap)
+	}
+	var (
+		fetcher = srv.fetcher
+		timeout = srv.recoveryTimeout
+	)
+	if fetcher == nil {
+		for _, gap := range gaps {
+			srv.monitor(gap)
 		}
+		srv.mu.Unlock()
+		return
 	}
+	records := srv.matchingRecords(eventKind)
+	srv.mu.Unlock()
+
+	// There is no event to be delivered after the events recovered.
+	held := &heldEvent{nil, gaps, records}
+	if recovery, ok := srv.recoveries[eventKind]; ok {
+		recovery.held = append(recovery.held, held)
+		return
+	}
+	srv.startRecovery(fetcher, timeout, held)
 }
 
 // Event handlers invocation ---------------------------------------------------
 
 func (srv *Service) invokeHandlers(event Event) {
 	srv.mu.Lock()
-	srv.updateSeqNum(event.Kind(), event.Seq())
+	gaps, err, deliver := srv.updateSeqNum(event.Kind(), event.Seq())
+	srv.stats.eventReceived(event, err)
+	for _, gap := range gaps {
+		srv.stats.gapDetected(gap)
+	}
+	if err != nil {
+		srv.monitor(err)
+	}
+	if !deliver {
+		srv.mu.Unlock()
+		return
+	}
+	var (
+		fetcher = srv.fetcher
+		timeout = srv.recoveryTimeout
+	)
+	if fetcher == nil {
+		for _, gap := range gaps {
+			srv.monitor(gap)
+		}
+		gaps = nil
+	}
+	records := srv.matchingRecords(event.Kind())
+	limits := srv.limits
+	srv.mu.Unlock()
+
+	// The events of a kind being recovered are held back to keep the order.
+	held := &heldEvent{event, gaps, records}
+	if recovery, ok := srv.recoveries[event.Kind()]; ok {
+		recovery.held = append(recovery.held, held)
+		return
+	}
+
+	// Try to fetch the missing events and deliver them first.
+	if len(gaps) != 0 {
+		srv.startRecovery(fetcher, timeout, held)
+		return
+	}
+
+	// The lock is not being held while dispatching since the handlers
+	// for channel listeners can block, see SubscribeChan.
+	for _, record := range srv.selectRecords(event, records) {
+		srv.dispatch(limits, record, event)
+	}
+}
+
+// matchingRecords returns the records of the listeners eventKind is to be
+// delivered to. srv.mu must be locked.
+func (srv *Service) matchingRecords(eventKind string) []*handlerRecord {
+	var records []*handlerRecord
 	srv.trie.VisitPrefixes(
-		patricia.Prefix(event.Kind()),
+		patricia.Prefix(eventKind),
 		func(prefix patricia.Prefix, item patricia.Item) error {
 			for _, record := range item.(*trieItem).records {
-				srv.runHandler(record.handler, event)
+				// Pattern listeners are filtered on the client side.
+				if record.pattern == nil || record.pattern.match(eventKind) {
+					records = append(records, record)
+				}
 			}
 			return nil
 		})
-	srv.mu.Unlock()
+	return records
 }
 
-func (srv *Service) runHandler(handler EventHandler, event Event) {
-	atomic.AddInt32(&srv.numRunningHandlers, 1)
-	go func() {
-		defer func() {
-			recover()
-			srv.handlerReturnedCh <- true
-		}()
-		handler(event)
+// newEventId returns a random 128-bit event ID encoded as a hex string.
+func newEventId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// callHandler invokes handler, recovering from any panic it may cause.
+func (srv *Service) callHandler(handler EventHandler, event Event) {
+	start := time.Now()
+	defer func() {
+		recover()
+		srv.stats.handlerReturned(event, time.Since(start))
 	}()
+	handler(event)
 }
 
 // Errors ----------------------------------------------------------------------
 
+// ErrEventSequenceGap is reported when some events were lost. The events are
+// only considered lost once enough events published after them have been
+// received or once they are not received in time, see SetGapTimeout, so that
+// reordered events are not reported as lost.
+// ExpectedSeq is the first event missing, ReceivedSeq follows the last one.
 type ErrEventSequenceGap struct {
 	EventKind   string
 	ExpectedSeq EventSeqNum
@@ -404,6 +798,23 @@ func (err *ErrEventSequenceGap) Error() string {
 		err.EventKind, err.ExpectedSeq, err.ReceivedSeq)
 }
 
-var (
-	ErrListenerHandlesDepleted = errors.New("EventListener handles depleted")
-)
+type ErrEventDecode struct {
+	EventKind string
+	Seq       EventSeqNum
+	Err       error
+}
+
+func (err *ErrEventDecode) Error() string {
+	return fmt.Sprintf("Failed to decode event %v #%v: %v", err.EventKind, err.Seq, err.Err)
+}
+
+type ErrEventDropped struct {
+	EventKind string
+	Seq       EventSeqNum
+	Listener  *Listener
+}
+
+func (err *ErrEventDropped) Error() string {
+	return fmt.Sprintf("Event %v #%v dropped for listener %v: handler limit reached",
+		err.EventKind, err.Seq, err.Listener.id)
+}
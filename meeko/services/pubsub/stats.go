@@ -0,0 +1,168 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the service statistics, see Service.Stats.
+type Stats struct {
+	// Statistics for every event kind received so far.
+	Kinds map[string]*KindStats
+
+	// The number of listeners registered.
+	Listeners int
+
+	// The number of kind prefixes the transport is subscribed for.
+	Subscriptions int
+
+	// The number of errors that were not sent to the monitoring channel
+	// because it was full.
+	MonitorOverflows uint64
+}
+
+// KindStats contains the statistics for a single event kind.
+type KindStats struct {
+	// The number of events received.
+	Events uint64
+
+	// The number of sequence gaps detected and the total number of events
+	// that were missing.
+	Gaps         uint64
+	MissedEvents uint64
+
+	// The number of duplicate and reordered events received.
+	Duplicates uint64
+	Reordered  uint64
+
+	// The number of events dropped because of handler limits.
+	Dropped uint64
+
+	// The sequence number of the most recent event received.
+	LastSeq EventSeqNum
+
+	// Handler latency statistics, i.e. for how long the handlers were running.
+	HandlerCalls     uint64
+	HandlerTimeTotal time.Duration
+	HandlerTimeMax   time.Duration
+}
+
+// AvgHandlerTime returns the average time the handlers were running.
+func (stats *KindStats) AvgHandlerTime() time.Duration {
+	if stats.HandlerCalls == 0 {
+		return 0
+	}
+	return stats.HandlerTimeTotal / time.Duration(stats.HandlerCalls)
+}
+
+// Stats returns the current service statistics.
+func (srv *Service) Stats() *Stats {
+	srv.mu.Lock()
+	listeners := len(srv.recordsByListener)
+	subscriptions := len(srv.subscriptions)
+	srv.mu.Unlock()
+
+	stats := srv.stats.snapshot()
+	stats.Listeners = listeners
+	stats.Subscriptions = subscriptions
+	return stats
+}
+
+// statsCollector collects the statistics. It has its own lock so that
+// the handlers do not contend for srv.mu when reporting their latencies.
+type statsCollector struct {
+	kinds            map[string]*KindStats
+	monitorOverflows uint64
+	mu               *sync.Mutex
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		kinds: make(map[string]*KindStats),
+		mu:    new(sync.Mutex),
+	}
+}
+
+// kind returns the stats for eventKind. sc.mu must be locked.
+func (sc *statsCollector) kind(eventKind string) *KindStats {
+	stats, ok := sc.kinds[eventKind]
+	if !ok {
+		stats = &KindStats{}
+		sc.kinds[eventKind] = stats
+	}
+	return stats
+}
+
+// eventReceived updates the stats for a received event. err is the error
+// returned by updateSeqNum.
+func (sc *statsCollector) eventReceived(event Event, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	stats := sc.kind(event.Kind())
+	stats.Events++
+
+	switch err.(type) {
+	case *ErrEventDuplicate:
+		stats.Duplicates++
+	case *ErrEventReordered:
+		stats.Reordered++
+	default:
+		if SeqDistance(stats.LastSeq, event.Seq()) > 0 || stats.Events == 1 {
+			stats.LastSeq = event.Seq()
+		}
+	}
+}
+
+// gapDetected updates the stats for the events lost.
+func (sc *statsCollector) gapDetected(gap *ErrEventSequenceGap) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	stats := sc.kind(gap.EventKind)
+	stats.Gaps++
+	stats.MissedEvents += uint64(SeqDistance(gap.ExpectedSeq, gap.ReceivedSeq))
+}
+
+func (sc *statsCollector) eventDropped(event Event) {
+	sc.mu.Lock()
+	sc.kind(event.Kind()).Dropped++
+	sc.mu.Unlock()
+}
+
+func (sc *statsCollector) handlerReturned(event Event, elapsed time.Duration) {
+	sc.mu.Lock()
+	stats := sc.kind(event.Kind())
+	stats.HandlerCalls++
+	stats.HandlerTimeTotal += elapsed
+	if elapsed > stats.HandlerTimeMax {
+		stats.HandlerTimeMax = elapsed
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *statsCollector) monitorOverflowed() {
+	sc.mu.Lock()
+	sc.monitorOverflows++
+	sc.mu.Unlock()
+}
+
+func (sc *statsCollector) snapshot() *Stats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	kinds := make(map[string]*KindStats, len(sc.kinds))
+	for kind, stats := range sc.kinds {
+		s := *stats
+		kinds[kind] = &s
+	}
+	return &Stats{
+		Kinds:            kinds,
+		MonitorOverflows: sc.monitorOverflows,
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+func TestStats_Kinds(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	rec := pubsubtest.NewRecorder()
+	if _, err := srv.Subscribe("a", rec.Handle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Subscribe("a.x", nopHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.DuplicateNext("a.x", 1)
+	transport.ReorderNext("a.y", 2)
+	for i := 0; i < 3; i++ {
+		for _, kind := range []string{"a.x", "a.y"} {
+			if err := srv.Publish(kind, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := rec.Wait(6, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubsubtest.WaitHandled(srv, 9, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := srv.Stats()
+	if stats.Listeners != 2 || stats.Subscriptions != 2 {
+		t.Errorf("expected 2 listeners and 2 subscriptions, got %v and %v",
+			stats.Listeners, stats.Subscriptions)
+	}
+
+	cases := []struct {
+		kind         string
+		events       uint64
+		duplicates   uint64
+		reordered    uint64
+		lastSeq      pubsub.EventSeqNum
+		handlerCalls uint64
+	}{
+		// The duplicate is counted as received, but it is not handled.
+		{"a.x", 4, 1, 0, 3, 6},
+		// Event 1 is received after 2.
+		{"a.y", 3, 0, 1, 3, 3},
+	}
+
+	for _, c := range cases {
+		ks, ok := stats.Kinds[c.kind]
+		if !ok {
+			t.Errorf("%v: no stats", c.kind)
+			continue
+		}
+		if ks.Events != c.events {
+			t.Errorf("%v: expected %v events, got %v", c.kind, c.events, ks.Events)
+		}
+		if ks.Duplicates != c.duplicates || ks.Reordered != c.reordered {
+			t.Errorf("%v: expected %v duplicates and %v reordered, got %v and %v",
+				c.kind, c.duplicates, c.reordered, ks.Duplicates, ks.Reordered)
+		}
+		if ks.LastSeq != c.lastSeq {
+			t.Errorf("%v: expected last seq %v, got %v", c.kind, c.lastSeq, ks.LastSeq)
+		}
+		if ks.HandlerCalls != c.handlerCalls {
+			t.Errorf("%v: expected %v handler calls, got %v", c.kind, c.handlerCalls, ks.HandlerCalls)
+		}
+		if ks.HandlerTimeMax < ks.AvgHandlerTime() || ks.HandlerTimeTotal < ks.HandlerTimeMax {
+			t.Errorf("%v: inconsistent handler times: %+v", c.kind, ks)
+		}
+	}
+}
+
+func TestStats_GapsAndDrops(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+	srv.SetGapTimeout(50 * time.Millisecond)
+
+	var (
+		rec  = pubsubtest.NewRecorder()
+		gate = make(chan struct{})
+	)
+	defer close(gate)
+	_, err := srv.SubscribeWithOptions("a", gatedHandler(rec, gate), &pubsub.SubscriptionOptions{
+		Limits: &pubsub.HandlerLimits{MaxHandlers: 1, Overflow: pubsub.OverflowDropNewest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events 2-4 are lost. The handler is blocked, so all the events received
+	// but the first one are dropped.
+	for i := 1; i <= 7; i++ {
+		if i == 2 {
+			transport.DropNext("a", 3)
+		}
+		if err := srv.Publish("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitDropped(t, srv, 3)
+
+	deadline := time.After(testTimeout)
+	for gapReported := false; !gapReported; {
+		select {
+		case err := <-errCh:
+			_, gapReported = err.(*pubsub.ErrEventSequenceGap)
+		case <-deadline:
+			t.Fatal("timed out waiting for the gap to be reported")
+		}
+	}
+
+	ks := srv.Stats().Kinds["a"]
+	if ks.Events != 4 {
+		t.Errorf("expected 4 events, got %v", ks.Events)
+	}
+	if ks.Gaps != 1 || ks.MissedEvents != 3 {
+		t.Errorf("expected 1 gap of 3 events, got %v and %v", ks.Gaps, ks.MissedEvents)
+	}
+	if ks.Dropped != 3 {
+		t.Errorf("expected 3 events dropped, got %v", ks.Dropped)
+	}
+}
+
+func TestStats_MonitorOverflows(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	// Only the first error fits into the channel.
+	errCh := make(chan error, 1)
+	srv.Monitor(errCh)
+	_, err := pubsub.SubscribeTyped(srv, "a", func(string, int, pubsub.EventMeta) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := srv.Publish("a", "not a number"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pubsubtest.WaitHandled(srv, 3, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := srv.Stats().MonitorOverflows; n != 2 {
+		t.Errorf("expected 2 errors to overflow, got %v", n)
+	}
+	if len(errCh) != 1 {
+		t.Errorf("expected 1 error in the channel, got %v", len(errCh))
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"strings"
+	"testing"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/services/pubsub/pubsubtest"
+)
+
+func nopHandler(pubsub.Event) {}
+
+func TestSubscribe_RefCount(t *testing.T) {
+	// Every step is followed by the expected transport subscriptions.
+	cases := []struct {
+		step          func(srv *pubsub.Service, listeners map[string]*pubsub.Listener) error
+		subscriptions string
+	}{
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) (err error) {
+				ls["a1"], err = srv.Subscribe("a", nopHandler)
+				return
+			},
+			"a",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) (err error) {
+				ls["a2"], err = srv.Subscribe("a", nopHandler)
+				return
+			},
+			"a",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) (err error) {
+				ls["ab"], err = srv.Subscribe("a.b", nopHandler)
+				return
+			},
+			"a a.b",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) error {
+				return ls["a1"].Close()
+			},
+			"a a.b",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) error {
+				return ls["a2"].Close()
+			},
+			"a.b",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) (err error) {
+				ls["c"], err = srv.Subscribe("c", nopHandler)
+				return
+			},
+			"a.b c",
+		},
+		{
+			func(srv *pubsub.Service, ls map[string]*pubsub.Listener) error {
+				return srv.Unsubscribe("a")
+			},
+			"c",
+		},
+	}
+
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	listeners := make(map[string]*pubsub.Listener)
+	for i, c := range cases {
+		if err := c.step(srv, listeners); err != nil {
+			t.Fatalf("step %v: %v", i, err)
+		}
+		if got := strings.Join(transport.Subscriptions(), " "); got != c.subscriptions {
+			t.Errorf("step %v: expected subscriptions %q, got %q", i, c.subscriptions, got)
+		}
+	}
+}
+
+func TestListener_Close(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	var (
+		recs      = make([]*pubsubtest.Recorder, 3)
+		listeners = make([]*pubsub.Listener, 3)
+	)
+	for i := range listeners {
+		recs[i] = pubsubtest.NewRecorder()
+		listener, err := srv.Subscribe("a", recs[i].Handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+	}
+
+	// Closing a listener twice must not affect the other listeners.
+	for i := 0; i < 2; i++ {
+		if err := listeners[0].Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := transport.Subscriptions(); len(got) != 1 {
+		t.Fatalf("expected the subscription to be kept, got %q", got)
+	}
+
+	if err := srv.Publish("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs[1:] {
+		if err := rec.Wait(1, testTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	barrier(t, srv)
+	if n := len(recs[0].Events()); n != 0 {
+		t.Errorf("expected the closed listener to receive no events, got %v", n)
+	}
+
+	// The listeners removed by Unsubscribe can still be closed.
+	if err := srv.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, listener := range listeners[1:] {
+		if err := listener.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := transport.Subscriptions(); len(got) != 0 {
+		t.Errorf("expected no subscriptions, got %q", got)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services"
+)
+
+// SubscribeSync works like SubscribeWithOptions, but it only returns once
+// the event sequence table for eventKindPrefix has been received and applied.
+// The table is returned, so it contains the sequence numbers of the last events
+// published before the subscription became active. That makes it possible to
+// combine the subscription with fetching a snapshot of the current state.
+//
+// In case the prefix is already covered by an active subscription, or the
+// transport does not support sequence tables, the table is assembled from
+// the sequence numbers known to the service.
+//
+// The listener is removed when ctx is done before the table arrives, or when
+// the table does not arrive in time, see SetSeqTableTimeout.
+func (srv *Service) SubscribeSync(ctx context.Context, eventKindPrefix string,
+	handler EventHandler, opts *SubscriptionOptions) (*Listener, EventSeqTable, error) {
+
+	if opts == nil {
+		opts = &SubscriptionOptions{}
+	}
+
+	srv.mu.Lock()
+	listener := srv.registerHandler(eventKindPrefix, nil, handler, opts)
+	if err := srv.subscribePrefix(eventKindPrefix); err != nil {
+		srv.mu.Unlock()
+		return nil, nil, err
+	}
+
+	// Wait for the pending table request covering the prefix, if there is any.
+	var waiter *seqTableWaiter
+	for i := len(srv.seqTableRequests) - 1; i >= 0; i-- {
+		req := srv.seqTableRequests[i]
+		if strings.HasPrefix(eventKindPrefix, req.kindPrefix) {
+			waiter = &seqTableWaiter{eventKindPrefix, make(chan EventSeqTable, 1)}
+			req.waiters = append(req.waiters, waiter)
+			break
+		}
+	}
+
+	// Otherwise the sequence numbers are known already.
+	if waiter == nil {
+		table := make(EventSeqTable)
+		for kind, window := range srv.seqWindowsByKind {
+			if strings.HasPrefix(kind, eventKindPrefix) {
+				table[kind] = window.current
+			}
+		}
+		srv.mu.Unlock()
+		return listener, table, nil
+	}
+	srv.mu.Unlock()
+
+	select {
+	case table := <-waiter.ch:
+		if table == nil {
+			srv.RemoveListener(listener)
+			return nil, nil, ErrSeqTableTimeout
+		}
+		return listener, table, nil
+	case <-ctx.Done():
+		srv.RemoveListener(listener)
+		return nil, nil, ctx.Err()
+	case <-srv.Closed():
+		return nil, nil, ErrTerminated
+	}
+}
+
+// DefaultSeqTableTimeout is the time the service waits for the sequence table
+// requested by subscribing the transport, unless set using SetSeqTableTimeout.
+const DefaultSeqTableTimeout = 10 * time.Second
+
+// SetSeqTableTimeout sets the time the service waits for the sequence table
+// requested by subscribing the transport, zero meaning DefaultSeqTableTimeout.
+// The waiting SubscribeSync calls fail with ErrSeqTableTimeout afterwards.
+func (srv *Service) SetSeqTableTimeout(timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DefaultSeqTableTimeout
+	}
+
+	srv.mu.Lock()
+	srv.seqTableTimeout = timeout
+	srv.mu.Unlock()
+}
+
+// seqTableRequest represents a sequence table requested by subscribing
+// the transport for kindPrefix.
+type seqTableRequest struct {
+	kindPrefix string
+	waiters    []*seqTableWaiter
+	timer      *time.Timer
+}
+
+// seqTableWaiter represents a SubscribeSync call waiting for a sequence table.
+// nil is sent to ch when the request expires.
+type seqTableWaiter struct {
+	kindPrefix string
+	ch         chan EventSeqTable
+}
+
+// expectSeqTable registers the sequence table request made by subscribing
+// the transport for kindPrefix, unless the transport does not support
+// sequence tables at all. srv.mu must be locked.
+func (srv *Service) expectSeqTable(kindPrefix string) {
+	if srv.transport.EventSeqTableChan() == nil {
+		return
+	}
+
+	req := &seqTableRequest{kindPrefix: kindPrefix}
+	req.timer = time.AfterFunc(srv.seqTableTimeout, func() {
+		srv.mu.Lock()
+		if srv.removeSeqTableRequest(req) {
+			for _, waiter := range req.waiters {
+				waiter.ch <- nil
+			}
+		}
+		srv.mu.Unlock()
+	})
+	srv.seqTableRequests = append(srv.seqTableRequests, req)
+}
+
+// removeSeqTableRequest returns false in case req is not pending any more.
+// srv.mu must be locked.
+func (srv *Service) removeSeqTableRequest(req *seqTableRequest) bool {
+	for i, r := range srv.seqTableRequests {
+		if r == req {
+			copy(srv.seqTableRequests[i:], srv.seqTableRequests[i+1:])
+			srv.seqTableRequests[len(srv.seqTableRequests)-1] = nil
+			srv.seqTableRequests = srv.seqTableRequests[:len(srv.seqTableRequests)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// seqTableReceived notifies the waiters for the table request seqTable
+// replies to, which is the oldest pending request with the prefix matching
+// all the kinds in the table. The tables do not carry the prefix, so an empty
+// table is taken for the reply to the oldest pending request.
+// srv.mu must be locked.
+func (srv *Service) seqTableReceived(seqTable EventSeqTable) {
+	var req *seqTableRequest
+	for _, r := range srv.seqTableRequests {
+		if coversTable(r.kindPrefix, seqTable) {
+			req = r
+			break
+		}
+	}
+	if req == nil {
+		return
+	}
+	req.timer.Stop()
+	srv.removeSeqTableRequest(req)
+
+	for _, waiter := range req.waiters {
+		table := make(EventSeqTable)
+		for kind, seq := range seqTable {
+			if strings.HasPrefix(kind, waiter.kindPrefix) {
+				table[kind] = seq
+			}
+		}
+		waiter.ch <- table
+	}
+}
+
+func coversTable(kindPrefix string, seqTable EventSeqTable) bool {
+	for kind := range seqTable {
+		if !strings.HasPrefix(kind, kindPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrTerminated      = &services.ErrTerminated{"PubSub service"}
+	ErrSeqTableTimeout = errors.New("timed out waiting for the event sequence table")
+)
@@ -0,0 +1,180 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+)
+
+type syncResult struct {
+	listener *pubsub.Listener
+	table    pubsub.EventSeqTable
+	err      error
+}
+
+// subscribeSync calls SubscribeSync in the background and waits until
+// the transport is subscribed for prefix.
+func subscribeSync(t *testing.T, ctx context.Context, srv *pubsub.Service,
+	transport interface{ Subscriptions() []string }, prefix string) <-chan *syncResult {
+
+	resCh := make(chan *syncResult, 1)
+	go func() {
+		listener, table, err := srv.SubscribeSync(ctx, prefix, nopHandler, nil)
+		resCh <- &syncResult{listener, table, err}
+	}()
+
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		for _, p := range transport.Subscriptions() {
+			if p == prefix {
+				return resCh
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q to be subscribed", prefix)
+	return nil
+}
+
+func waitSync(t *testing.T, resCh <-chan *syncResult) *syncResult {
+	select {
+	case res := <-resCh:
+		return res
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for SubscribeSync to return")
+		return nil
+	}
+}
+
+func TestSubscribeSync(t *testing.T) {
+	cases := []struct {
+		name     string
+		seqs     pubsub.EventSeqTable
+		prefix   string
+		expected pubsub.EventSeqTable
+	}{
+		{
+			"no events",
+			nil,
+			"a",
+			pubsub.EventSeqTable{},
+		},
+		{
+			"matching kinds",
+			pubsub.EventSeqTable{"a.x": 5, "a.y": 7, "b.x": 1},
+			"a",
+			pubsub.EventSeqTable{"a.x": 5, "a.y": 7},
+		},
+	}
+
+	for _, c := range cases {
+		srv, transport := newTestService(t)
+		for kind, seq := range c.seqs {
+			transport.SetSeq(kind, seq)
+		}
+
+		_, table, err := srv.SubscribeSync(context.Background(), c.prefix, nopHandler, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(table, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.name, c.expected, table)
+		}
+		srv.Close()
+	}
+}
+
+func TestSubscribeSync_AlreadySubscribed(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	transport.SetSeq("a.x", 5)
+
+	if _, _, err := srv.SubscribeSync(context.Background(), "a", nopHandler, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, table, err := srv.SubscribeSync(context.Background(), "a", nopHandler, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (pubsub.EventSeqTable{"a.x": 5}); !reflect.DeepEqual(table, expected) {
+		t.Errorf("expected %v, got %v", expected, table)
+	}
+}
+
+func TestSubscribeSync_MatchesPrefix(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	transport.DropNextSeqTables(1)
+	resCh := subscribeSync(t, context.Background(), srv, transport, "a")
+
+	// A table for another prefix must not be taken for the reply,
+	// that would make SubscribeSync return an empty table.
+	transport.InjectSeqTable(pubsub.EventSeqTable{"b.x": 1})
+	transport.InjectSeqTable(pubsub.EventSeqTable{"a.x": 3})
+	res := waitSync(t, resCh)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if expected := (pubsub.EventSeqTable{"a.x": 3}); !reflect.DeepEqual(res.table, expected) {
+		t.Errorf("expected %v, got %v", expected, res.table)
+	}
+}
+
+func TestSubscribeSync_Expired(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+	srv.SetSeqTableTimeout(10 * time.Millisecond)
+
+	transport.DropNextSeqTables(1)
+	res := waitSync(t, subscribeSync(t, context.Background(), srv, transport, "a"))
+	if res.err != pubsub.ErrSeqTableTimeout {
+		t.Fatalf("expected ErrSeqTableTimeout, got %v", res.err)
+	}
+	if got := transport.Subscriptions(); len(got) != 0 {
+		t.Errorf("expected the listener to be removed, got subscriptions %q", got)
+	}
+
+	// The late table must not be taken for the reply to the next request.
+	transport.DropNextSeqTables(1)
+	srv.SetSeqTableTimeout(testTimeout)
+	resCh := subscribeSync(t, context.Background(), srv, transport, "b")
+	transport.InjectSeqTable(pubsub.EventSeqTable{"a.x": 1})
+	transport.InjectSeqTable(pubsub.EventSeqTable{"b.x": 2})
+	res = waitSync(t, resCh)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if expected := (pubsub.EventSeqTable{"b.x": 2}); !reflect.DeepEqual(res.table, expected) {
+		t.Errorf("expected %v, got %v", expected, res.table)
+	}
+}
+
+func TestSubscribeSync_Canceled(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	transport.DropNextSeqTables(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	resCh := subscribeSync(t, ctx, srv, transport, "a")
+	cancel()
+
+	res := waitSync(t, resCh)
+	if res.err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", res.err)
+	}
+	if got := transport.Subscriptions(); len(got) != 0 {
+		t.Errorf("expected the listener to be removed, got subscriptions %q", got)
+	}
+}
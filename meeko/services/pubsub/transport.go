@@ -5,7 +5,11 @@
 
 package pubsub
 
-import "github.com/meeko/go-meeko/meeko/services"
+import (
+	"time"
+
+	"github.com/meeko/go-meeko/meeko/services"
+)
 
 //------------------------------------------------------------------------------
 // Transport
@@ -16,10 +20,10 @@ type Transport interface {
 	services.Transport
 
 	// Publish does exactly what the name says - it publishes the given event
-	// object under eventKind.
+	// object under eventKind. props must be delivered together with the event.
 	//
 	// eventObject must be marshallable by github.com/ugorji/go/codec.
-	Publish(eventKind string, eventObject interface{}) error
+	Publish(eventKind string, eventObject interface{}, props *EventProps) error
 
 	// Subscribe sets this transport's event filter to receive all events
 	// having their kind starting with eventKindPrefix.
@@ -46,6 +50,33 @@ type Transport interface {
 	ErrorChan() <-chan error
 }
 
+// AckingTransport is implemented by the transports that can tell the sequence
+// numbers assigned to the events published using them, see OnPublished.
+type AckingTransport interface {
+	Transport
+
+	// PublishAcked works like Publish, but once the sequence number assigned
+	// to the event is known, the event is sent to the channel returned by
+	// AckChan. The acknowledgements are not guaranteed to arrive.
+	PublishAcked(eventKind string, eventObject interface{}, props *EventProps) error
+
+	// AckChan returns a channel for receiving the events acknowledged.
+	AckChan() <-chan *PublishedEvent
+}
+
+// PublishedEvent is an event published by the service using the transport.
+type PublishedEvent struct {
+	Kind      string
+	Seq       EventSeqNum
+	Publisher string
+
+	// The properties sent together with the event, possibly nil.
+	Props *EventProps
+
+	// The event object encoded using MessagePack.
+	Body []byte
+}
+
 // Event represents an event received on a transport.
 type Event interface {
 	// Kind returns the event kind this event was published as.
@@ -54,12 +85,34 @@ type Event interface {
 	// Seq returns this event's sequence number.
 	Seq() EventSeqNum
 
+	// Publisher returns the identity of the app that published this event.
+	Publisher() string
+
+	// Id returns the unique ID assigned to this event by the publisher.
+	// It is empty in case the publisher did not send it.
+	Id() string
+
+	// Timestamp returns the time this event was published at.
+	// It is zero in case the publisher did not send it.
+	Timestamp() time.Time
+
+	// Headers returns the headers attached to this event, possibly nil.
+	Headers() EventHeaders
+
 	// Unmarshal unmarshalls the received event into dst, which must support
 	// decoding using github.com/ugorji/go/codec.
 	Unmarshal(dst interface{}) error
 }
 
 type (
-	EventSeqNum   uint32
+	EventSeqNum   uint64
 	EventSeqTable map[string]EventSeqNum
+	EventHeaders  map[string]string
 )
+
+// EventProps contains the event properties set by the publisher.
+type EventProps struct {
+	Id        string
+	Timestamp time.Time
+	Headers   EventHeaders
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import "time"
+
+// EventMeta contains the event properties other than the event object itself.
+type EventMeta struct {
+	Seq       EventSeqNum
+	Publisher string
+	Id        string
+	Timestamp time.Time
+	Headers   EventHeaders
+}
+
+func newEventMeta(event Event) EventMeta {
+	return EventMeta{
+		Seq:       event.Seq(),
+		Publisher: event.Publisher(),
+		Id:        event.Id(),
+		Timestamp: event.Timestamp(),
+		Headers:   event.Headers(),
+	}
+}
+
+// SubscribeTyped works like Service.Subscribe, but the events are decoded into
+// a value of type T before handler is invoked. Events that cannot be decoded
+// are reported as *ErrEventDecode to the channel registered using Monitor and
+// handler is not invoked for them at all.
+func SubscribeTyped[T any](srv *Service, eventKindPrefix string,
+	handler func(kind string, v T, meta EventMeta)) (*Listener, error) {
+
+	return srv.Subscribe(eventKindPrefix, func(event Event) {
+		var v T
+		if err := event.Unmarshal(&v); err != nil {
+			srv.report(&ErrEventDecode{
+				EventKind: event.Kind(),
+				Seq:       event.Seq(),
+				Err:       err,
+			})
+			return
+		}
+
+		handler(event.Kind(), v, newEventMeta(event))
+	})
+}
+
+// Publisher publishes event objects of type T.
+type Publisher[T any] struct {
+	srv *Service
+}
+
+// NewPublisher returns a Publisher using srv to publish the events.
+func NewPublisher[T any](srv *Service) *Publisher[T] {
+	return &Publisher[T]{srv}
+}
+
+// Publish publishes v under eventKind.
+func (pub *Publisher[T]) Publish(eventKind string, v T) error {
+	return pub.srv.Publish(eventKind, v)
+}
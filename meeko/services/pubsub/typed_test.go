@@ -0,0 +1,133 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+)
+
+type buildFinished struct {
+	Name     string
+	Duration int
+}
+
+type typedEvent struct {
+	kind string
+	v    buildFinished
+	meta pubsub.EventMeta
+}
+
+func subscribeTyped(t *testing.T, srv *pubsub.Service, prefix string) <-chan typedEvent {
+	eventCh := make(chan typedEvent, 10)
+	_, err := pubsub.SubscribeTyped(srv, prefix,
+		func(kind string, v buildFinished, meta pubsub.EventMeta) {
+			eventCh <- typedEvent{kind, v, meta}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eventCh
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	eventCh := subscribeTyped(t, srv, "build.")
+
+	sent := buildFinished{"meeko", 42}
+	if err := pubsub.NewPublisher[buildFinished](srv).Publish("build.finished", sent); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-eventCh:
+		if event.kind != "build.finished" {
+			t.Errorf("expected kind build.finished, got %v", event.kind)
+		}
+		if event.v != sent {
+			t.Errorf("expected %+v, got %+v", sent, event.v)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the event")
+	}
+}
+
+func TestSubscribeTyped_Meta(t *testing.T) {
+	srv, transport := newTestService(t)
+	defer srv.Close()
+
+	eventCh := subscribeTyped(t, srv, "build.")
+
+	props := &pubsub.EventProps{
+		Id:        "0123456789abcdef",
+		Timestamp: time.Unix(1380000000, 0),
+		Headers:   pubsub.EventHeaders{"trace": "1"},
+	}
+	err := transport.Inject("builder", "build.finished", 7, buildFinished{"meeko", 1}, props)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-eventCh:
+		meta := event.meta
+		if meta.Seq != 7 {
+			t.Errorf("expected seq 7, got %v", meta.Seq)
+		}
+		if meta.Publisher != "builder" {
+			t.Errorf("expected publisher builder, got %v", meta.Publisher)
+		}
+		if meta.Id != props.Id {
+			t.Errorf("expected id %v, got %v", props.Id, meta.Id)
+		}
+		if !meta.Timestamp.Equal(props.Timestamp) {
+			t.Errorf("expected timestamp %v, got %v", props.Timestamp, meta.Timestamp)
+		}
+		if len(meta.Headers) != 1 || meta.Headers["trace"] != "1" {
+			t.Errorf("expected headers %v, got %v", props.Headers, meta.Headers)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the event")
+	}
+}
+
+func TestSubscribeTyped_DecodeError(t *testing.T) {
+	srv, _ := newTestService(t)
+	defer srv.Close()
+
+	errCh := make(chan error, 10)
+	srv.Monitor(errCh)
+
+	eventCh := subscribeTyped(t, srv, "build.")
+
+	if err := srv.Publish("build.finished", "not a struct"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errCh:
+		derr, ok := err.(*pubsub.ErrEventDecode)
+		if !ok {
+			t.Fatalf("expected ErrEventDecode, got %v", err)
+		}
+		if derr.EventKind != "build.finished" || derr.Seq != 1 || derr.Err == nil {
+			t.Errorf("unexpected error: %+v", derr)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the error")
+	}
+
+	barrier(t, srv)
+	if len(eventCh) != 0 {
+		t.Error("expected the handler not to be invoked")
+	}
+}
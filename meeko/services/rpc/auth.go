@@ -0,0 +1,141 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	// Stdlib
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// Authorizer decides whether an incoming request is allowed to be handled.
+//
+// Authorize is called for every request before the relevant request handler
+// is invoked. When it returns an error, the request is resolved with
+// ReturnCodeForbidden and the error message as the return value.
+type Authorizer interface {
+	Authorize(sender, method string) error
+}
+
+// AuthorizerFunc is an adapter to allow the use of ordinary functions
+// as authorizers.
+type AuthorizerFunc func(sender, method string) error
+
+func (fnc AuthorizerFunc) Authorize(sender, method string) error {
+	return fnc(sender, method)
+}
+
+// ACL ------------------------------------------------------------------------
+
+// ACL is an Authorizer using a list of rules mapping method patterns to
+// the sender patterns allowed to call the methods. The patterns use the syntax
+// of path.Match.
+//
+// The rules are checked in the order they were added and the first rule with
+// the method pattern matching the method being called decides. Methods not
+// matching any rule are allowed for everybody.
+type ACL struct {
+	Rules []*ACLRule `json:"rules"`
+}
+
+type ACLRule struct {
+	Method  string   `json:"method"`
+	Senders []string `json:"senders"`
+}
+
+// NewACL returns an empty ACL, which allows everything.
+func NewACL() *ACL {
+	return &ACL{}
+}
+
+// ReadACL decodes an ACL from r. The expected format is JSON, e.g.
+//
+//	{"rules": [{"method": "admin.*", "senders": ["ops", "deployer-*"]}]}
+func ReadACL(r io.Reader) (*ACL, error) {
+	var acl ACL
+	if err := json.NewDecoder(r).Decode(&acl); err != nil {
+		return nil, err
+	}
+	if err := acl.validate(); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// ReadACLFile reads an ACL from the file at filename, see ReadACL.
+func ReadACLFile(filename string) (*ACL, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadACL(file)
+}
+
+// Allow appends a rule allowing senders to call methods matching method.
+// An ACL rule with no senders denies everybody.
+//
+// Allow panics when any of the patterns is malformed.
+func (acl *ACL) Allow(method string, senders ...string) *ACL {
+	rule := &ACLRule{method, senders}
+	if err := rule.validate(); err != nil {
+		panic(err)
+	}
+	acl.Rules = append(acl.Rules, rule)
+	return acl
+}
+
+func (acl *ACL) Authorize(sender, method string) error {
+	for _, rule := range acl.Rules {
+		if ok, _ := path.Match(rule.Method, method); !ok {
+			continue
+		}
+
+		for _, pattern := range rule.Senders {
+			if ok, _ := path.Match(pattern, sender); ok {
+				return nil
+			}
+		}
+		return &ErrForbidden{sender, method}
+	}
+	return nil
+}
+
+func (acl *ACL) validate() error {
+	for _, rule := range acl.Rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rule *ACLRule) validate() error {
+	if _, err := path.Match(rule.Method, ""); err != nil {
+		return fmt.Errorf("invalid ACL method pattern %q: %v", rule.Method, err)
+	}
+	for _, pattern := range rule.Senders {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ACL sender pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Errors ----------------------------------------------------------------------
+
+type ErrForbidden struct {
+	Sender string
+	Method string
+}
+
+func (err *ErrForbidden) Error() string {
+	return fmt.Sprintf("%v is not allowed to call %v", err.Sender, err.Method)
+}
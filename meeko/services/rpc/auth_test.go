@@ -0,0 +1,181 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	// Stdlib
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/services/rpc/rpctest"
+)
+
+func TestACL_Authorize(t *testing.T) {
+	acl := rpc.NewACL().
+		Allow("admin.shutdown", "ops").
+		Allow("admin.*", "ops", "deployer-*").
+		Allow("secret.*")
+
+	cases := []struct {
+		sender  string
+		method  string
+		allowed bool
+	}{
+		// The first matching rule decides, the second one is never consulted.
+		{"ops", "admin.shutdown", true},
+		{"deployer-1", "admin.shutdown", false},
+		{"deployer-1", "admin.deploy", true},
+		{"builder", "admin.deploy", false},
+		// A rule with no senders denies everybody.
+		{"ops", "secret.get", false},
+		// The methods not matching any rule are allowed.
+		{"builder", "build.run", true},
+		{"", "admin", true},
+	}
+
+	for _, c := range cases {
+		err := acl.Authorize(c.sender, c.method)
+		if c.allowed {
+			if err != nil {
+				t.Errorf("%v calling %v: unexpected error: %v", c.sender, c.method, err)
+			}
+			continue
+		}
+		if ferr, ok := err.(*rpc.ErrForbidden); !ok {
+			t.Errorf("%v calling %v: expected ErrForbidden, got %v", c.sender, c.method, err)
+		} else if ferr.Sender != c.sender || ferr.Method != c.method {
+			t.Errorf("%v calling %v: unexpected error: %v", c.sender, c.method, err)
+		}
+	}
+}
+
+func TestACL_EmptyAllowsEverything(t *testing.T) {
+	if err := rpc.NewACL().Authorize("anybody", "anything"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestACL_AllowInvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Allow to panic")
+		}
+	}()
+	rpc.NewACL().Allow("admin.[", "ops")
+}
+
+func TestReadACL(t *testing.T) {
+	acl, err := rpc.ReadACL(strings.NewReader(
+		`{"rules": [{"method": "admin.*", "senders": ["ops", "deployer-*"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Authorize("deployer-2", "admin.deploy"); err != nil {
+		t.Error(err)
+	}
+	if err := acl.Authorize("builder", "admin.deploy"); err == nil {
+		t.Error("expected builder to be forbidden")
+	}
+}
+
+func TestReadACL_Invalid(t *testing.T) {
+	inputs := []string{
+		`{"rules": [`,
+		`{"rules": [{"method": "admin.[", "senders": ["ops"]}]}`,
+		`{"rules": [{"method": "admin.*", "senders": ["ops", "deployer-\\"]}]}`,
+	}
+
+	for _, input := range inputs {
+		if _, err := rpc.ReadACL(strings.NewReader(input)); err == nil {
+			t.Errorf("%v: expected an error", input)
+		}
+	}
+}
+
+func TestAuthorizerFunc(t *testing.T) {
+	errDenied := errors.New("denied")
+	authorizer := rpc.AuthorizerFunc(func(sender, method string) error {
+		if sender == "ops" {
+			return nil
+		}
+		return errDenied
+	})
+
+	if err := authorizer.Authorize("ops", "admin.deploy"); err != nil {
+		t.Error(err)
+	}
+	if err := authorizer.Authorize("builder", "admin.deploy"); err != errDenied {
+		t.Errorf("expected %v, got %v", errDenied, err)
+	}
+}
+
+func TestSetAuthorizer_Forbidden(t *testing.T) {
+	transport := rpctest.NewTransport("test")
+	srv, err := rpc.NewService(transport.Factory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	var invoked int32
+	err = srv.RegisterMethod("admin.deploy", func(request rpc.RemoteRequest) {
+		atomic.AddInt32(&invoked, 1)
+		request.Resolve(0, "deployed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetAuthorizer(rpc.NewACL().Allow("admin.*", "ops")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		sender     string
+		returnCode rpc.ReturnCode
+		invoked    int32
+	}{
+		{"builder", rpc.ReturnCodeForbidden, 0},
+		{"ops", 0, 1},
+	}
+
+	for _, c := range cases {
+		req, err := transport.Inject(c.sender, "admin.deploy", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-req.Resolved():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: timed out waiting for the request to be resolved", c.sender)
+		}
+
+		if code := req.ReturnCode(); code != c.returnCode {
+			t.Errorf("%v: expected return code %v, got %v", c.sender, c.returnCode, code)
+		}
+		if n := atomic.LoadInt32(&invoked); n != c.invoked {
+			t.Errorf("%v: expected the handler to be invoked %v times, got %v", c.sender, c.invoked, n)
+		}
+	}
+
+	// The error message is sent back as the return value.
+	req, err := transport.Inject("builder", "admin.deploy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Wait()
+	var msg string
+	if err := req.UnmarshalReturnValue(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&rpc.ErrForbidden{"builder", "admin.deploy"}).Error(); msg != expected {
+		t.Errorf("expected return value %q, got %q", expected, msg)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	// Stdlib
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// DefaultReplyCacheSize is the number of replies kept in the reply cache
+// unless changed by calling SetReplyCacheSize.
+const DefaultReplyCacheSize = 1000
+
+// replyCache is a caller-side LRU cache of replies for the methods that were
+// marked as cacheable. The cache key consists of the method name and the
+// arguments encoded using canonical MessagePack, so that maps with the same
+// content yield the same key no matter the iteration order.
+//
+// replyCache also keeps track of the calls that are in flight, so that
+// identical calls issued at the same time are merged into a single request.
+type replyCache struct {
+	ttls    map[string]time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int
+	flights map[string]*flight
+	mu      *sync.Mutex
+}
+
+// flight is a call in flight that the identical calls are merged into.
+type flight struct {
+	leader    *RemoteCall
+	followers int
+}
+
+type cacheEntry struct {
+	key     string
+	reply   RemoteCallReply
+	expires time.Time
+}
+
+func newReplyCache() *replyCache {
+	return &replyCache{
+		ttls:    make(map[string]time.Duration),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		size:    DefaultReplyCacheSize,
+		flights: make(map[string]*flight),
+		mu:      new(sync.Mutex),
+	}
+}
+
+// setTTL marks method as cacheable. Zero ttl disables caching for method and
+// drops all the replies that are already cached for it.
+func (cache *replyCache) setTTL(method string, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if ttl > 0 {
+		cache.ttls[method] = ttl
+		return
+	}
+
+	delete(cache.ttls, method)
+	prefix := method + "\x00"
+	for key, elem := range cache.entries {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			cache.lru.Remove(elem)
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func (cache *replyCache) setSize(size int) {
+	cache.mu.Lock()
+	cache.size = size
+	cache.evict()
+	cache.mu.Unlock()
+}
+
+func (cache *replyCache) purge() {
+	cache.mu.Lock()
+	cache.entries = make(map[string]*list.Element)
+	cache.lru.Init()
+	cache.mu.Unlock()
+}
+
+// key returns the cache key for call, or an empty string when the call is not
+// eligible for caching. Calls that stream output or expect progress signals
+// are never cached since there is nothing to replay them from.
+func (cache *replyCache) key(call *RemoteCall) string {
+	if call.Stdout != nil || call.Stderr != nil || call.OnProgress != nil {
+		return ""
+	}
+
+	cache.mu.Lock()
+	_, ok := cache.ttls[call.method]
+	cache.mu.Unlock()
+	if !ok {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(call.method)
+	buf.WriteByte(0)
+	if err := codecs.MessagePackCanonical.Encode(&buf, call.args); err != nil {
+		// Let the transport fail on encoding the arguments.
+		return ""
+	}
+	return buf.String()
+}
+
+// get returns the cached reply for key if there is any that is not expired.
+func (cache *replyCache) get(key string) (RemoteCallReply, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		cache.lru.Remove(elem)
+		delete(cache.entries, key)
+		return nil, false
+	}
+
+	cache.lru.MoveToFront(elem)
+	return entry.reply, true
+}
+
+// put stores reply under key. Only successful replies are cached.
+func (cache *replyCache) put(key, method string, reply RemoteCallReply) {
+	if reply.ReturnCode() != ReturnCodeSuccess {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	ttl, ok := cache.ttls[method]
+	if !ok {
+		return
+	}
+
+	entry := &cacheEntry{key, reply, time.Now().Add(ttl)}
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value = entry
+		cache.lru.MoveToFront(elem)
+		return
+	}
+
+	cache.entries[key] = cache.lru.PushFront(entry)
+	cache.evict()
+}
+
+func (cache *replyCache) evict() {
+	for cache.lru.Len() > cache.size {
+		elem := cache.lru.Back()
+		cache.lru.Remove(elem)
+		delete(cache.entries, elem.Value.(*cacheEntry).key)
+	}
+}
+
+// join attaches a follower to the call that is currently in flight for key.
+// It returns false when there is no such call.
+//
+// join and the methods below are only used from within the dispatcher loop.
+func (cache *replyCache) join(key string) (*RemoteCall, bool) {
+	f, ok := cache.flights[key]
+	if !ok {
+		return nil, false
+	}
+
+	// Drop the flight in case it has been already resolved in some other way
+	// than by receiving a reply, e.g. because the transport failed to send it.
+	select {
+	case <-f.leader.resolvedCh:
+		delete(cache.flights, key)
+		return nil, false
+	default:
+		f.followers++
+		return f.leader, true
+	}
+}
+
+// startFlight registers leader as the call in flight for key, with a single
+// follower attached.
+func (cache *replyCache) startFlight(key string, leader *RemoteCall) {
+	cache.flights[key] = &flight{leader, 1}
+}
+
+// leave detaches a follower from the call in flight for key. It returns true
+// when that was the last follower, the leader being no longer needed then.
+func (cache *replyCache) leave(key string, leader *RemoteCall) bool {
+	f, ok := cache.flights[key]
+	if !ok || f.leader != leader {
+		return false
+	}
+
+	f.followers--
+	return f.followers == 0
+}
+
+func (cache *replyCache) endFlight(key string, leader *RemoteCall) {
+	if f, ok := cache.flights[key]; ok && f.leader == leader {
+		delete(cache.flights, key)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpc_test
+
+import (
+	// Stdlib
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/services/rpc/rpctest"
+)
+
+func newCachingService(t *testing.T) (*rpc.Service, *rpctest.Transport) {
+	transport := rpctest.NewTransport("test")
+	srv, err := rpc.NewService(transport.Factory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.CacheReplies("get", time.Minute)
+	return srv, transport
+}
+
+func TestReplyCache_MapArgs(t *testing.T) {
+	srv, transport := newCachingService(t)
+	defer srv.Close()
+	transport.SetReply("get", &rpctest.Reply{ReturnValue: "value"})
+
+	for i := 0; i < 10; i++ {
+		args := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+		if err := srv.NewRemoteCall("get", args).Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(transport.Calls()); n != 1 {
+		t.Errorf("expected 1 call to be sent, got %v", n)
+	}
+}
+
+func TestReplyCache_FailedRepliesNotCached(t *testing.T) {
+	srv, transport := newCachingService(t)
+	defer srv.Close()
+	transport.SetReply("get", &rpctest.Reply{ReturnCode: 1, ReturnValue: "error"})
+
+	for i := 0; i < 2; i++ {
+		if err := srv.NewRemoteCall("get", 0).Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(transport.Calls()); n != 2 {
+		t.Errorf("expected 2 calls to be sent, got %v", n)
+	}
+}
+
+func TestReplyCache_LeaderInterrupted(t *testing.T) {
+	srv, transport := newCachingService(t)
+	defer srv.Close()
+
+	// There is no reply registered, so the calls stay in flight.
+	calls := []*rpc.RemoteCall{
+		srv.NewRemoteCall("get", 1).GoExecute(),
+		srv.NewRemoteCall("get", 1).GoExecute(),
+	}
+	if n := len(transport.Calls()); n != 1 {
+		t.Fatalf("expected the calls to be merged, got %v calls", n)
+	}
+
+	calls[0].Interrupt()
+	if n := len(transport.Interrupts()); n != 0 {
+		t.Fatalf("leader interrupted while there is a call attached")
+	}
+	calls[1].Interrupt()
+
+	for _, call := range calls {
+		if err := call.Wait(); err != rpc.ErrInterrupted {
+			t.Errorf("expected ErrInterrupted, got %v", err)
+		}
+	}
+	interrupts := transport.Interrupts()
+	if len(interrupts) != 1 || interrupts[0] != transport.Calls()[0].Id {
+		t.Fatalf("leader not interrupted: %v", interrupts)
+	}
+
+	// The next identical call is not attached to the interrupted leader.
+	transport.SetReply("get", &rpctest.Reply{ReturnValue: "value"})
+	if err := srv.NewRemoteCall("get", 1).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(transport.Calls()); n != 2 {
+		t.Errorf("expected 2 calls to be sent, got %v", n)
+	}
+}
@@ -22,6 +22,11 @@ type RemoteCall struct {
 
 	dispatchedFlag  uint32
 	interruptedFlag uint32
+	resolvedFlag    uint32
+
+	// Reply caching, see dispatcher.CacheReplies.
+	cacheKey string
+	leader   *RemoteCall
 
 	method string
 	args   interface{}
@@ -135,10 +140,16 @@ func (call *RemoteCall) interrupted() bool {
 	return atomic.LoadUint32(&call.interruptedFlag) != 0
 }
 
-func (call *RemoteCall) resolve(reply RemoteCallReply, err error) {
+// resolve returns false when the call has been resolved already.
+func (call *RemoteCall) resolve(reply RemoteCallReply, err error) bool {
+	// Make sure the call is resolved only once, the first result wins.
+	if !atomic.CompareAndSwapUint32(&call.resolvedFlag, 0, 1) {
+		return false
+	}
 	call.reply = reply
 	call.err = err
 	close(call.resolvedCh)
+	return true
 }
 
 // Errors ----------------------------------------------------------------------
@@ -8,6 +8,8 @@ package rpc
 import (
 	log "github.com/cihub/seelog"
 	"io"
+	"sync/atomic"
+	"time"
 )
 
 type dispatcher struct {
@@ -25,6 +27,7 @@ type dispatcher struct {
 
 	taskManager *asyncTaskManager
 	idPool      *idPool
+	cache       *replyCache
 
 	err error
 }
@@ -42,6 +45,7 @@ func newDispatcher(transport Transport) *dispatcher {
 		termAckCh:   make(chan struct{}),
 		taskManager: newAsyncTaskManager(),
 		idPool:      newIdPool(),
+		cache:       newReplyCache(),
 	}
 
 	go disp.loop()
@@ -54,6 +58,30 @@ func (disp *dispatcher) NewRemoteCall(method string, args interface{}) *RemoteCa
 	return newRemoteCall(disp, method, args)
 }
 
+// CacheReplies marks method as cacheable. Successful replies to the calls of
+// method are then kept in a local LRU cache for ttl and repeated calls with
+// the same arguments are resolved from the cache without contacting the broker.
+// Identical calls that are in flight at the same time are merged into a single
+// request as well, which is interrupted once all the calls merged into it are
+// interrupted or abandoned.
+//
+// Calls with Stdout, Stderr or OnProgress set are never cached.
+//
+// Calling CacheReplies with zero ttl disables caching for method.
+func (disp *dispatcher) CacheReplies(method string, ttl time.Duration) {
+	disp.cache.setTTL(method, ttl)
+}
+
+// SetReplyCacheSize sets the maximum number of replies kept in the reply cache.
+func (disp *dispatcher) SetReplyCacheSize(size int) {
+	disp.cache.setSize(size)
+}
+
+// PurgeReplyCache drops all the cached replies.
+func (disp *dispatcher) PurgeReplyCache() {
+	disp.cache.purge()
+}
+
 // Private API for Service -----------------------------------------------------
 
 func (disp *dispatcher) shutdown() {
@@ -104,6 +132,8 @@ func (cmd *executeCmd) ErrorChan() chan<- error {
 }
 
 func (disp *dispatcher) executeRemoteCall(call *RemoteCall) {
+	call.cacheKey = disp.cache.key(call)
+
 	errCh := make(chan error, 1)
 	select {
 	case disp.executeCh <- &executeCmd{call, errCh}:
@@ -176,6 +206,12 @@ func (disp *dispatcher) loop() {
 				continue
 			}
 
+			// Serve cacheable calls from the cache if possible.
+			if cmd.call.cacheKey != "" {
+				disp.executeCachedCall(cmd)
+				continue
+			}
+
 			// Allocate necessary resources and register the call.
 			disp.registerCall(cmd.call)
 
@@ -185,12 +221,36 @@ func (disp *dispatcher) loop() {
 		// interruptCh contains outgoing interrupts, i.e. interrupts for
 		// the remote requests initiated by this Service instance.
 		case cmd := <-disp.interruptCh:
+			// There is nothing to interrupt if the call is resolved already.
+			select {
+			case <-cmd.call.resolvedCh:
+				cmd.errCh <- nil
+				continue
+			default:
+			}
+
+			// Calls merged into another call are just detached from it.
+			if cmd.call.leader != nil {
+				disp.detachCall(cmd.call)
+				cmd.errCh <- nil
+				continue
+			}
+
 			disp.transport.Interrupt(cmd)
 
 		// abandonCh contains calls that are to be dropped, i.e. unregistered
 		// without really waiting for the reply to arrive.
 		case call := <-disp.abandonCh:
+			// Calls merged into another call have no resources allocated.
+			if call.leader != nil {
+				disp.detachCall(call)
+				continue
+			}
+
 			// Release the resources allocated by the call.
+			if call.cacheKey != "" {
+				disp.cache.endFlight(call.cacheKey, call)
+			}
 			disp.unregisterCall(call)
 
 			// Resolve the call.
@@ -257,12 +317,87 @@ func (disp *dispatcher) loop() {
 			}
 
 			call.resolve(reply, nil)
+			// Cache the reply if the call is cacheable.
+			if call.cacheKey != "" {
+				disp.cache.put(call.cacheKey, call.method, reply)
+				disp.cache.endFlight(call.cacheKey, call)
+			}
 			// Free resources connected to the call.
 			disp.unregisterCall(call)
 		}
 	}
 }
 
+// executeCachedCall resolves the call from the reply cache. When there is no
+// reply cached, the call is attached to the identical call in flight, which is
+// created and dispatched first if necessary.
+func (disp *dispatcher) executeCachedCall(cmd *executeCmd) {
+	call := cmd.call
+
+	if reply, ok := disp.cache.get(call.cacheKey); ok {
+		cmd.errCh <- nil
+		call.resolve(reply, nil)
+		return
+	}
+
+	leader, ok := disp.cache.join(call.cacheKey)
+	if !ok {
+		// The leader is a call owned by the dispatcher, so that none of
+		// the merged calls can interrupt it for the others.
+		leader = newRemoteCall(disp, call.method, call.args)
+		leader.dispatchedFlag = 1
+		leader.cacheKey = call.cacheKey
+		disp.cache.startFlight(leader.cacheKey, leader)
+		disp.registerCall(leader)
+
+		errCh := make(chan error, 1)
+		disp.transport.Call(&executeCmd{leader, errCh})
+		go func() {
+			// The leader might have been abandoned by detachCall already.
+			if err := <-errCh; err != nil && leader.resolve(nil, err) {
+				select {
+				case disp.abandonCh <- leader:
+				case <-disp.termCh:
+				}
+			}
+		}()
+	}
+
+	call.leader = leader
+	cmd.errCh <- nil
+	go func() {
+		<-leader.resolvedCh
+		call.resolve(leader.reply, leader.err)
+	}()
+}
+
+// detachCall resolves a call merged into another call as interrupted.
+// The leader is interrupted and abandoned once it has no calls attached,
+// so that a call that never returns is not kept in flight forever.
+func (disp *dispatcher) detachCall(call *RemoteCall) {
+	// Calls resolved already are detached from the leader already.
+	select {
+	case <-call.resolvedCh:
+		return
+	default:
+	}
+	call.resolve(nil, ErrInterrupted)
+
+	leader := call.leader
+	if !disp.cache.leave(call.cacheKey, leader) {
+		return
+	}
+	disp.cache.endFlight(leader.cacheKey, leader)
+
+	// The leader is being abandoned in some other way already.
+	if !leader.resolve(nil, ErrInterrupted) {
+		return
+	}
+	atomic.StoreUint32(&leader.interruptedFlag, 1)
+	disp.transport.Interrupt(&interruptCmd{leader, make(chan error, 1)})
+	disp.unregisterCall(leader)
+}
+
 func (disp *dispatcher) registerCall(call *RemoteCall) {
 	// Assign the call an id and register it with the service.
 	call.id = disp.allocateRequestId()
@@ -16,11 +16,13 @@ type executor struct {
 	transport Transport
 
 	methodHandlers map[string]RequestHandler
+	authorizer     Authorizer
 	taskManager    *asyncTaskManager
 
 	registerCh   chan *registerCmd
 	unregisterCh chan *unregisterCmd
 	deleteCh     chan *string
+	authorizerCh chan Authorizer
 	termCh       chan struct{}
 	termAckCh    chan struct{}
 }
@@ -33,6 +35,7 @@ func newExecutor(transport Transport) *executor {
 		registerCh:     make(chan *registerCmd),
 		unregisterCh:   make(chan *unregisterCmd),
 		deleteCh:       make(chan *string),
+		authorizerCh:   make(chan Authorizer),
 		termCh:         make(chan struct{}),
 		termAckCh:      make(chan struct{}),
 	}
@@ -130,6 +133,21 @@ func (exec *executor) deleteMethod(method string) (err error) {
 	return
 }
 
+// SetAuthorizer sets the Authorizer that is consulted for every incoming
+// request before the relevant request handler is invoked. Requests that are
+// not authorized are resolved with ReturnCodeForbidden.
+//
+// Passing nil disables authorization, which is the default.
+func (exec *executor) SetAuthorizer(authorizer Authorizer) (err error) {
+	select {
+	case exec.authorizerCh <- authorizer:
+	case <-exec.termCh:
+		err = ErrTerminated
+	}
+
+	return
+}
+
 // Private API for Server ------------------------------------------------------
 
 func (exec *executor) shutdown() {
@@ -174,6 +192,10 @@ func (exec *executor) loop() {
 		case method := <-exec.deleteCh:
 			delete(exec.methodHandlers, *method)
 
+		// authorizerCh accepts requests for the authorizer to be replaced.
+		case authorizer := <-exec.authorizerCh:
+			exec.authorizer = authorizer
+
 		// RequestChan contains incoming RPC requests.
 		case request := <-exec.transport.RequestChan():
 			handler, ok := exec.methodHandlers[request.Method()]
@@ -183,6 +205,16 @@ func (exec *executor) loop() {
 				continue
 			}
 
+			// Reject the request in case it is not authorized.
+			if exec.authorizer != nil {
+				if err := exec.authorizer.Authorize(request.Sender(), request.Method()); err != nil {
+					exec.taskManager.Go(func() {
+						request.Resolve(ReturnCodeForbidden, err.Error())
+					})
+					continue
+				}
+			}
+
 			exec.taskManager.Go(func() {
 				handler(request)
 			})
@@ -198,7 +230,7 @@ func (exec *executor) loop() {
 					return
 
 				case request := <-exec.transport.RequestChan():
-					request.Resolve(254, "terminating")
+					request.Resolve(ReturnCodeTerminating, "terminating")
 				}
 			}
 		}
@@ -0,0 +1,181 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	// Stdlib
+	"bytes"
+	"io"
+	"sync"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// Request implements rpc.RemoteRequest. It is created by Transport.Inject
+// and it records everything the request handler does with it.
+type Request struct {
+	sender string
+	id     rpc.RequestID
+	method string
+	args   []byte
+
+	progress    int
+	stdout      bytes.Buffer
+	stderr      bytes.Buffer
+	returnCode  rpc.ReturnCode
+	returnValue []byte
+	mu          *sync.Mutex
+
+	interrupted   chan struct{}
+	interruptOnce *sync.Once
+	resolved      chan struct{}
+}
+
+func newRequest(sender string, id rpc.RequestID, method string, args []byte) *Request {
+	return &Request{
+		sender:        sender,
+		id:            id,
+		method:        method,
+		args:          args,
+		mu:            new(sync.Mutex),
+		interrupted:   make(chan struct{}),
+		interruptOnce: new(sync.Once),
+		resolved:      make(chan struct{}),
+	}
+}
+
+// Interrupt interrupts the request the same way the broker would.
+func (req *Request) Interrupt() {
+	req.interruptOnce.Do(func() {
+		close(req.interrupted)
+	})
+}
+
+// Wait blocks until the request is resolved.
+func (req *Request) Wait() {
+	<-req.resolved
+}
+
+// ProgressCount returns how many times the progress was signalled.
+func (req *Request) ProgressCount() int {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return req.progress
+}
+
+// StdoutBytes returns everything written into the stdout stream so far.
+func (req *Request) StdoutBytes() []byte {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return append([]byte(nil), req.stdout.Bytes()...)
+}
+
+// StderrBytes returns everything written into the stderr stream so far.
+func (req *Request) StderrBytes() []byte {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return append([]byte(nil), req.stderr.Bytes()...)
+}
+
+// ReturnCode returns the code the request was resolved with.
+//
+// ReturnCode must be called after the request is resolved, otherwise it panics.
+func (req *Request) ReturnCode() rpc.ReturnCode {
+	select {
+	case <-req.resolved:
+		return req.returnCode
+	default:
+		panic(rpc.ErrNotResolvedYet)
+	}
+}
+
+// UnmarshalReturnValue decodes the value the request was resolved with into dst.
+//
+// UnmarshalReturnValue must be called after the request is resolved,
+// otherwise it panics.
+func (req *Request) UnmarshalReturnValue(dst interface{}) error {
+	select {
+	case <-req.resolved:
+		return codecs.MessagePack.Decode(bytes.NewReader(req.returnValue), dst)
+	default:
+		panic(rpc.ErrNotResolvedYet)
+	}
+}
+
+// rpc.RemoteRequest interface -------------------------------------------------
+
+func (req *Request) Sender() string {
+	return req.sender
+}
+
+func (req *Request) Id() rpc.RequestID {
+	return req.id
+}
+
+func (req *Request) Method() string {
+	return req.method
+}
+
+func (req *Request) UnmarshalArgs(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(req.args), dst)
+}
+
+func (req *Request) SignalProgress() error {
+	req.mu.Lock()
+	req.progress++
+	req.mu.Unlock()
+	return nil
+}
+
+func (req *Request) Stdout() io.Writer {
+	return &streamWriter{req, &req.stdout}
+}
+
+func (req *Request) Stderr() io.Writer {
+	return &streamWriter{req, &req.stderr}
+}
+
+func (req *Request) Interrupted() <-chan struct{} {
+	return req.interrupted
+}
+
+func (req *Request) Resolve(returnCode rpc.ReturnCode, returnValue interface{}) error {
+	var valueBuffer bytes.Buffer
+	if err := codecs.MessagePack.Encode(&valueBuffer, returnValue); err != nil {
+		return err
+	}
+
+	req.mu.Lock()
+	defer req.mu.Unlock()
+
+	select {
+	case <-req.resolved:
+		return ErrResolved
+	default:
+	}
+
+	req.returnCode = returnCode
+	req.returnValue = valueBuffer.Bytes()
+	close(req.resolved)
+	return nil
+}
+
+func (req *Request) Resolved() <-chan struct{} {
+	return req.resolved
+}
+
+type streamWriter struct {
+	req *Request
+	buf *bytes.Buffer
+}
+
+func (w *streamWriter) Write(p []byte) (n int, err error) {
+	w.req.mu.Lock()
+	defer w.req.mu.Unlock()
+	return w.buf.Write(p)
+}
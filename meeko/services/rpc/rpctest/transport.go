@@ -0,0 +1,394 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package rpctest provides an in-memory rpc.Transport implementation that can
+// be used for unit testing code built on top of rpc.Service without any
+// broker running.
+//
+// Outgoing calls are recorded and answered using the replies registered by
+// calling SetReply. Incoming requests can be injected into the executor using
+// Inject and the way they were resolved can be inspected afterwards.
+package rpctest
+
+import (
+	// Stdlib
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services"
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// Reply is a canned reply to be sent for every call of a method.
+type Reply struct {
+	// Number of progress signals to be sent before the reply.
+	Progress int
+
+	// Frames to be sent to the stdout and stderr streams before the reply.
+	// The frames are dropped if the caller did not ask for the stream.
+	Stdout [][]byte
+	Stderr [][]byte
+
+	// How long to wait before sending the reply.
+	Delay time.Duration
+
+	ReturnCode  rpc.ReturnCode
+	ReturnValue interface{}
+}
+
+// Call is a call recorded by Transport.
+type Call struct {
+	Id        rpc.RequestID
+	Method    string
+	StdoutTag *rpc.StreamTag
+	StderrTag *rpc.StreamTag
+
+	args []byte
+}
+
+// UnmarshalArgs decodes the call arguments into dst.
+func (call *Call) UnmarshalArgs(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(call.args), dst)
+}
+
+// Transport implements rpc.Transport in memory.
+//
+// All methods are thread-safe.
+type Transport struct {
+	identity string
+
+	// Canned replies and recorded commands.
+	replies    map[string]*Reply
+	methods    map[string]bool
+	calls      []*Call
+	interrupts []rpc.RequestID
+	nextId     rpc.RequestID
+	mu         *sync.Mutex
+
+	// Output interface for the Service using this Transport.
+	requestCh   chan rpc.RemoteRequest
+	progressCh  chan rpc.RequestID
+	streamingCh chan rpc.StreamFrame
+	replyCh     chan rpc.RemoteCallReply
+	errorCh     chan error
+
+	// Termination management
+	closedCh  chan struct{}
+	closeOnce *sync.Once
+	err       error
+}
+
+// NewTransport creates a new Transport. identity is used as the sender
+// of the requests that are not given any other sender.
+func NewTransport(identity string) *Transport {
+	return &Transport{
+		identity:    identity,
+		replies:     make(map[string]*Reply),
+		methods:     make(map[string]bool),
+		mu:          new(sync.Mutex),
+		requestCh:   make(chan rpc.RemoteRequest),
+		progressCh:  make(chan rpc.RequestID),
+		streamingCh: make(chan rpc.StreamFrame),
+		replyCh:     make(chan rpc.RemoteCallReply),
+		errorCh:     make(chan error, 1),
+		closedCh:    make(chan struct{}),
+		closeOnce:   new(sync.Once),
+	}
+}
+
+// Factory returns a function that can be passed to rpc.NewService.
+func (t *Transport) Factory() rpc.ServiceFactory {
+	return func() (rpc.Transport, error) {
+		return t, nil
+	}
+}
+
+// Canned replies and recorded commands ----------------------------------------
+
+// SetReply registers reply to be sent for every call of method.
+// Passing nil reply removes the reply, which makes the calls of method
+// pending until ReplyTo is used to reply.
+func (t *Transport) SetReply(method string, reply *Reply) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if reply == nil {
+		delete(t.replies, method)
+		return
+	}
+	t.replies[method] = reply
+}
+
+// ReplyTo sends reply for call. It can be used for replying to the calls
+// of the methods with no canned reply registered.
+//
+// ReplyTo blocks until the reply is received by the Service.
+func (t *Transport) ReplyTo(call *Call, reply *Reply) error {
+	return t.sendReply(call, reply)
+}
+
+// Calls returns all the calls recorded so far.
+func (t *Transport) Calls() []*Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Call(nil), t.calls...)
+}
+
+// CallsOf returns all the calls of method recorded so far.
+func (t *Transport) CallsOf(method string) []*Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var calls []*Call
+	for _, call := range t.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Interrupts returns the IDs of all the calls interrupted so far.
+func (t *Transport) Interrupts() []rpc.RequestID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]rpc.RequestID(nil), t.interrupts...)
+}
+
+// IsRegistered returns true if method is currently registered.
+func (t *Transport) IsRegistered(method string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.methods[method]
+}
+
+// Inject dispatches a new request for method to the Service using this
+// Transport. The request looks like being sent by sender.
+//
+// Inject blocks until the request is received by the Service.
+func (t *Transport) Inject(sender, method string, args interface{}) (*Request, error) {
+	var argsBuffer bytes.Buffer
+	if err := codecs.MessagePack.Encode(&argsBuffer, args); err != nil {
+		return nil, err
+	}
+
+	if sender == "" {
+		sender = t.identity
+	}
+
+	t.mu.Lock()
+	t.nextId++
+	id := t.nextId
+	t.mu.Unlock()
+
+	req := newRequest(sender, id, method, argsBuffer.Bytes())
+	select {
+	case t.requestCh <- req:
+	case <-t.closedCh:
+		return nil, ErrTerminated
+	}
+	return req, nil
+}
+
+// Fail emits err as an internal transport error, which makes the Service
+// using this Transport terminate.
+func (t *Transport) Fail(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+
+	select {
+	case t.errorCh <- err:
+	default:
+	}
+}
+
+// rpc.Transport interface -----------------------------------------------------
+
+func (t *Transport) RegisterMethod(cmd rpc.RegisterCmd) {
+	t.mu.Lock()
+	t.methods[cmd.Method()] = true
+	t.mu.Unlock()
+	cmd.ErrorChan() <- nil
+}
+
+func (t *Transport) UnregisterMethod(cmd rpc.UnregisterCmd) {
+	t.mu.Lock()
+	delete(t.methods, cmd.Method())
+	t.mu.Unlock()
+	cmd.ErrorChan() <- nil
+}
+
+func (t *Transport) RequestChan() <-chan rpc.RemoteRequest {
+	return t.requestCh
+}
+
+func (t *Transport) Call(cmd rpc.CallCmd) {
+	var argsBuffer bytes.Buffer
+	if err := codecs.MessagePack.Encode(&argsBuffer, cmd.Args()); err != nil {
+		cmd.ErrorChan() <- err
+		return
+	}
+
+	call := &Call{
+		Id:        cmd.RequestId(),
+		Method:    cmd.Method(),
+		StdoutTag: cmd.StdoutTag(),
+		StderrTag: cmd.StderrTag(),
+		args:      argsBuffer.Bytes(),
+	}
+
+	t.mu.Lock()
+	t.calls = append(t.calls, call)
+	reply, ok := t.replies[call.Method]
+	t.mu.Unlock()
+
+	cmd.ErrorChan() <- nil
+
+	if ok {
+		go t.sendReply(call, reply)
+	}
+}
+
+func (t *Transport) Interrupt(cmd rpc.InterruptCmd) {
+	t.mu.Lock()
+	t.interrupts = append(t.interrupts, cmd.TargetRequestId())
+	t.mu.Unlock()
+	cmd.ErrorChan() <- nil
+}
+
+func (t *Transport) ProgressChan() <-chan rpc.RequestID {
+	return t.progressCh
+}
+
+func (t *Transport) StreamFrameChan() <-chan rpc.StreamFrame {
+	return t.streamingCh
+}
+
+func (t *Transport) ReplyChan() <-chan rpc.RemoteCallReply {
+	return t.replyCh
+}
+
+func (t *Transport) ErrorChan() <-chan error {
+	return t.errorCh
+}
+
+func (t *Transport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closedCh)
+	})
+	return nil
+}
+
+func (t *Transport) Closed() <-chan struct{} {
+	return t.closedCh
+}
+
+func (t *Transport) Wait() error {
+	<-t.Closed()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Private methods -------------------------------------------------------------
+
+func (t *Transport) sendReply(call *Call, reply *Reply) error {
+	var valueBuffer bytes.Buffer
+	if err := codecs.MessagePack.Encode(&valueBuffer, reply.ReturnValue); err != nil {
+		return err
+	}
+
+	if reply.Delay != 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-t.closedCh:
+			return ErrTerminated
+		}
+	}
+
+	for i := 0; i < reply.Progress; i++ {
+		select {
+		case t.progressCh <- call.Id:
+		case <-t.closedCh:
+			return ErrTerminated
+		}
+	}
+
+	if err := t.sendFrames(call.StdoutTag, reply.Stdout); err != nil {
+		return err
+	}
+	if err := t.sendFrames(call.StderrTag, reply.Stderr); err != nil {
+		return err
+	}
+
+	select {
+	case t.replyCh <- &remoteCallReply{call.Id, reply.ReturnCode, valueBuffer.Bytes()}:
+	case <-t.closedCh:
+		return ErrTerminated
+	}
+	return nil
+}
+
+func (t *Transport) sendFrames(tag *rpc.StreamTag, frames [][]byte) error {
+	if tag == nil {
+		return nil
+	}
+
+	for _, payload := range frames {
+		select {
+		case t.streamingCh <- &streamFrame{*tag, payload}:
+		case <-t.closedCh:
+			return ErrTerminated
+		}
+	}
+	return nil
+}
+
+// rpc.StreamFrame -------------------------------------------------------------
+
+type streamFrame struct {
+	tag     rpc.StreamTag
+	payload []byte
+}
+
+func (frame *streamFrame) TargetStreamTag() rpc.StreamTag {
+	return frame.tag
+}
+
+func (frame *streamFrame) Payload() []byte {
+	return frame.payload
+}
+
+// rpc.RemoteCallReply ---------------------------------------------------------
+
+type remoteCallReply struct {
+	id          rpc.RequestID
+	returnCode  rpc.ReturnCode
+	returnValue []byte
+}
+
+func (reply *remoteCallReply) TargetCallId() rpc.RequestID {
+	return reply.id
+}
+
+func (reply *remoteCallReply) ReturnCode() rpc.ReturnCode {
+	return reply.returnCode
+}
+
+func (reply *remoteCallReply) UnmarshalReturnValue(dst interface{}) error {
+	return codecs.MessagePack.Decode(bytes.NewReader(reply.returnValue), dst)
+}
+
+// Errors ----------------------------------------------------------------------
+
+var (
+	ErrResolved   = errors.New("request already resolved")
+	ErrTerminated = &services.ErrTerminated{"rpctest transport"}
+)
@@ -22,6 +22,13 @@ const (
 	CmdClose
 )
 
+// Standard return codes used by the library itself.
+const (
+	ReturnCodeSuccess     ReturnCode = 0
+	ReturnCodeForbidden   ReturnCode = 251
+	ReturnCodeTerminating ReturnCode = 254
+)
+
 type Service struct {
 	transport Transport
 	*executor
@@ -8,9 +8,11 @@ package pubsub
 import (
 	"bytes"
 	"encoding/binary"
+	"time"
 
-	"github.com/meeko/meekod/broker/services/pubsub"
+	client "github.com/meeko/go-meeko/meeko/services/pubsub"
 	"github.com/meeko/go-meeko/meeko/utils/codecs"
+	"github.com/meeko/meekod/broker/services/pubsub"
 )
 
 // Event implements pubsub.Event, which is the interface required by the exchange.
@@ -18,20 +20,27 @@ type Event struct {
 	kind      []byte
 	seq       []byte
 	publisher []byte
+	props     client.EventProps
 	body      []byte
 }
 
-func newEvent(identity string, eventKind string, eventObject interface{}) (pubsub.Event, error) {
+func newEvent(identity string, eventKind string, eventObject interface{},
+	props *client.EventProps) (pubsub.Event, error) {
+
 	var buf bytes.Buffer
 	if err := codecs.MessagePack.Encode(&buf, eventObject); err != nil {
 		return nil, err
 	}
 
-	return &Event{
+	event := &Event{
 		kind:      []byte(eventKind),
 		publisher: []byte(identity),
 		body:      buf.Bytes(),
-	}, nil
+	}
+	if props != nil {
+		event.props = *props
+	}
+	return event, nil
 }
 
 func (event *Event) Publisher() []byte {
@@ -61,3 +70,15 @@ func (event *Event) SetSeq(seq pubsub.EventSeqNum) {
 func (event *Event) Body() []byte {
 	return event.body
 }
+
+func (event *Event) Id() string {
+	return event.props.Id
+}
+
+func (event *Event) Timestamp() time.Time {
+	return event.props.Timestamp
+}
+
+func (event *Event) Headers() client.EventHeaders {
+	return event.props.Headers
+}
@@ -7,9 +7,9 @@ package pubsub
 
 import (
 	// Meeko
+	client "github.com/meeko/go-meeko/meeko/services/pubsub"
 	"github.com/meeko/meekod/broker"
 	"github.com/meeko/meekod/broker/services/pubsub"
-	client "github.com/meeko/go-meeko/meeko/services/pubsub"
 
 	// Other
 	"github.com/dmotylev/nutrition"
@@ -96,8 +96,8 @@ func (config *TransportConfig) NewTransport(identity string, exchange pubsub.Exc
 
 // client.Transport interface --------------------------------------------------
 
-func (t *Transport) Publish(eventKind string, eventObject interface{}) error {
-	event, err := newEvent(t.identity, eventKind, eventObject)
+func (t *Transport) Publish(eventKind string, eventObject interface{}, props *client.EventProps) error {
+	event, err := newEvent(t.identity, eventKind, eventObject, props)
 	if err != nil {
 		return err
 	}
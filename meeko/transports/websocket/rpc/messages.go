@@ -95,7 +95,11 @@ func (req *remoteRequest) Method() string {
 }
 
 func (req *remoteRequest) UnmarshalArgs(dst interface{}) error {
-	return codecs.MessagePack.Decode(bytes.NewReader(req.msg[5]), dst)
+	args, err := req.t.unpack(req.msg, 5, 8)
+	if err != nil {
+		return err
+	}
+	return codecs.MessagePack.Decode(bytes.NewReader(args), dst)
 }
 
 func (req *remoteRequest) SignalProgress() error {
@@ -148,13 +152,23 @@ type streamWriter struct {
 }
 
 func (w *streamWriter) Write(p []byte) (n int, err error) {
-	err = frames.C.Send(w.transport.conn, [][]byte{
+	payload, compressed, err := codecs.Compress(p, w.transport.compressionThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := [][]byte{
 		w.receiver,
 		frameHeader,
 		frameStreamFrameMT,
 		w.tag,
-		p,
-	})
+		payload,
+	}
+	if compressed {
+		msg = append(msg, frameFlagsCompressed)
+	}
+
+	err = frames.C.Send(w.transport.conn, msg)
 	if err == nil {
 		n = len(p)
 	}
@@ -165,8 +179,12 @@ func (w *streamWriter) Write(p []byte) (n int, err error) {
 
 type streamFrame [][]byte
 
-func newStreamFrame(msg [][]byte) rpc.StreamFrame {
-	return streamFrame(msg)
+func newStreamFrame(t *Transport, msg [][]byte) (rpc.StreamFrame, error) {
+	payload, err := t.unpack(msg, 4, 5)
+	if err != nil {
+		return nil, err
+	}
+	return streamFrame([][]byte{msg[0], msg[1], msg[2], msg[3], payload}), nil
 }
 
 func (frame streamFrame) TargetStreamTag() rpc.StreamTag {
@@ -185,14 +203,17 @@ func (frame streamFrame) Payload() []byte {
 
 // rpc.RemoteCallReply ---------------------------------------------------------
 
-type remoteCallReply [][]byte
+type remoteCallReply struct {
+	t   *Transport
+	msg [][]byte
+}
 
-func newReply(msg [][]byte) rpc.RemoteCallReply {
-	return remoteCallReply(msg)
+func newReply(t *Transport, msg [][]byte) rpc.RemoteCallReply {
+	return &remoteCallReply{t, msg}
 }
 
-func (reply remoteCallReply) TargetCallId() rpc.RequestID {
-	msg := [][]byte(reply)
+func (reply *remoteCallReply) TargetCallId() rpc.RequestID {
+	msg := reply.msg
 	var id rpc.RequestID
 	if err := binary.Read(bytes.NewReader(msg[3]), binary.BigEndian, &id); err != nil {
 		panic(err)
@@ -200,14 +221,25 @@ func (reply remoteCallReply) TargetCallId() rpc.RequestID {
 	return id
 }
 
-func (reply remoteCallReply) ReturnCode() rpc.ReturnCode {
-	msg := [][]byte(reply)
-	return rpc.ReturnCode(msg[4][0])
+func (reply *remoteCallReply) ReturnCode() rpc.ReturnCode {
+	return rpc.ReturnCode(reply.msg[4][0])
+}
+
+func (reply *remoteCallReply) UnmarshalReturnValue(dst interface{}) error {
+	value, err := reply.t.unpack(reply.msg, 5, 6)
+	if err != nil {
+		return err
+	}
+	return codecs.MessagePack.Decode(bytes.NewReader(value), dst)
 }
 
-func (reply remoteCallReply) UnmarshalReturnValue(dst interface{}) error {
-	msg := [][]byte(reply)
-	return codecs.MessagePack.Decode(bytes.NewReader(msg[5]), dst)
+// unpack returns msg[payload], decompressed in case the optional flags frame
+// msg[flags] is present and has FlagCompressed set.
+func (t *Transport) unpack(msg [][]byte, payload, flags int) ([]byte, error) {
+	if len(msg) <= flags || msg[flags][0]&FlagCompressed == 0 {
+		return msg[payload], nil
+	}
+	return codecs.Decompress(msg[payload], t.maxDecompressedSize)
 }
 
 // Errors ----------------------------------------------------------------------
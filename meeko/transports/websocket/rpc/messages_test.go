@@ -0,0 +1,152 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	// Stdlib
+	"bytes"
+	"testing"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+// payloadFrames returns value encoded with MessagePack, both as it is and
+// compressed, and the number of bytes it encodes to.
+func payloadFrames(t *testing.T, value interface{}) (plain, compressed []byte, size int) {
+	var buf bytes.Buffer
+	if err := codecs.MessagePack.Encode(&buf, value); err != nil {
+		t.Fatal(err)
+	}
+	plain = buf.Bytes()
+
+	compressed, ok, err := codecs.Compress(plain, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the payload was not compressed")
+	}
+	return plain, compressed, len(plain)
+}
+
+// unpackCase is a payload frame followed by an optional flags frame,
+// flags being nil meaning that the frame is omitted, to be unpacked
+// by a Transport limiting the decompressed size to max.
+type unpackCase struct {
+	name    string
+	payload []byte
+	flags   []byte
+	max     int
+	err     error
+}
+
+func unpackCases(t *testing.T, value interface{}) []unpackCase {
+	plain, compressed, size := payloadFrames(t, value)
+	return []unpackCase{
+		{"no flags frame", plain, nil, 0, nil},
+		{"flags not set", plain, []byte{0}, 0, nil},
+		{"compressed", compressed, frameFlagsCompressed, 0, nil},
+		{"compressed at the limit", compressed, frameFlagsCompressed, size, nil},
+		{"compressed over the limit", compressed, frameFlagsCompressed, size - 1, codecs.ErrPayloadTooLarge},
+	}
+}
+
+// withFlags appends flags to msg unless it is nil.
+func withFlags(msg [][]byte, flags []byte) [][]byte {
+	if flags != nil {
+		msg = append(msg, flags)
+	}
+	return msg
+}
+
+func TestRemoteRequest_UnmarshalArgs(t *testing.T) {
+	args := map[string]string{"text": string(bytes.Repeat([]byte("meeko"), 100))}
+
+	for _, c := range unpackCases(t, args) {
+		transport := &Transport{maxDecompressedSize: c.max}
+		msg := withFlags([][]byte{
+			[]byte("sender"),
+			frameHeader,
+			frameRequestMT,
+			[]byte{0, 1},
+			[]byte("method"),
+			c.payload,
+			nil,
+			nil,
+		}, c.flags)
+
+		var dst map[string]string
+		err := newRequest(transport, msg).UnmarshalArgs(&dst)
+		if err != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+		if err == nil && dst["text"] != args["text"] {
+			t.Errorf("%v: the arguments do not match", c.name)
+		}
+	}
+}
+
+func TestRemoteCallReply_UnmarshalReturnValue(t *testing.T) {
+	value := bytes.Repeat([]byte("meeko"), 100)
+
+	for _, c := range unpackCases(t, value) {
+		transport := &Transport{maxDecompressedSize: c.max}
+		msg := withFlags([][]byte{
+			nil,
+			frameHeader,
+			frameReplyMT,
+			[]byte{0, 1},
+			[]byte{byte(rpc.ReturnCodeSuccess)},
+			c.payload,
+		}, c.flags)
+
+		var dst []byte
+		err := newReply(transport, msg).UnmarshalReturnValue(&dst)
+		if err != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(dst, value) {
+			t.Errorf("%v: the return value does not match", c.name)
+		}
+	}
+}
+
+func TestStreamFrame_Payload(t *testing.T) {
+	value := bytes.Repeat([]byte("meeko"), 100)
+
+	for _, c := range unpackCases(t, value) {
+		transport := &Transport{maxDecompressedSize: c.max}
+		msg := withFlags([][]byte{
+			nil,
+			frameHeader,
+			frameStreamFrameMT,
+			[]byte{0, 1},
+			c.payload,
+		}, c.flags)
+
+		frame, err := newStreamFrame(transport, msg)
+		if err != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		var dst []byte
+		if err := codecs.MessagePack.Decode(bytes.NewReader(frame.Payload()), &dst); err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(dst, value) {
+			t.Errorf("%v: the payload does not match", c.name)
+		}
+	}
+}
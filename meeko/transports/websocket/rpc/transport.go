@@ -44,6 +44,18 @@ type TransportFactory struct {
 	//
 	// This field is required but preset by NewTransportFactory.
 	WSConfigFunc func(config *ws.Config)
+
+	// Arguments, return values and stream frames of at least this many bytes
+	// are compressed, zero meaning that compression is disabled. Compressed
+	// payloads are decompressed transparently no matter what the threshold is.
+	//
+	// Compressed payloads are signalled by an extra flags frame, so the server
+	// and the other apps must support it when compression is enabled.
+	CompressionThreshold int
+
+	// Compressed payloads decompressing to more than this many bytes are
+	// rejected, zero meaning codecs.DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
 }
 
 func NewTransportFactory() *TransportFactory {
@@ -77,6 +89,10 @@ type Transport struct {
 	incomingRequests map[string]*remoteRequest
 	requestsMu       *sync.Mutex
 
+	// Compression settings, see TransportFactory.
+	compressionThreshold int
+	maxDecompressedSize  int
+
 	// Output interface for the Service using this Transport
 	requestCh   chan rpc.RemoteRequest
 	progressCh  chan rpc.RequestID
@@ -125,6 +141,9 @@ func (factory *TransportFactory) NewTransport(identity string) (rpc.Transport, e
 		errorCh:          make(chan error),
 		termCh:           make(chan struct{}),
 		loopTermAckCh:    make(chan struct{}),
+
+		compressionThreshold: factory.CompressionThreshold,
+		maxDecompressedSize:  factory.MaxDecompressedSize,
 	}
 
 	go t.loop()
@@ -174,6 +193,11 @@ func (t *Transport) Call(cmd rpc.CallCmd) {
 		cmd.ErrorChan() <- err
 		return
 	}
+	args, compressed, err := codecs.Compress(argsBuffer.Bytes(), t.compressionThreshold)
+	if err != nil {
+		cmd.ErrorChan() <- err
+		return
+	}
 
 	// Marshal the stdout tag.
 	var stdoutTagBuffer bytes.Buffer
@@ -188,16 +212,20 @@ func (t *Transport) Call(cmd rpc.CallCmd) {
 	}
 
 	// Construct and send the message.
-	cmd.ErrorChan() <- frames.C.Send(t.conn, [][]byte{
+	msg := [][]byte{
 		frameEmpty,
 		frameHeader,
 		frameRequestMT,
 		idBuffer.Bytes(),
 		[]byte(cmd.Method()),
-		argsBuffer.Bytes(),
+		args,
 		stdoutTagBuffer.Bytes(),
 		stderrTagBuffer.Bytes(),
-	})
+	}
+	if compressed {
+		msg = append(msg, frameFlagsCompressed)
+	}
+	cmd.ErrorChan() <- frames.C.Send(t.conn, msg)
 }
 
 func (t *Transport) Interrupt(cmd rpc.InterruptCmd) {
@@ -275,6 +303,12 @@ const (
 	MessageTypePong
 )
 
+// Flags that can be sent in the optional last frame of REQUEST, STREAMFRAME
+// and REPLY messages.
+const (
+	FlagCompressed byte = 1 << iota
+)
+
 var (
 	frameEmpty  = []byte{}
 	frameHeader = []byte(Header)
@@ -287,6 +321,8 @@ var (
 	frameStreamFrameMT = []byte{MessageTypeStreamFrame}
 	frameReplyMT       = []byte{MessageTypeReply}
 	framePongMT        = []byte{MessageTypePong}
+
+	frameFlagsCompressed = []byte{FlagCompressed}
 )
 
 var pongMessage = [][]byte{
@@ -344,11 +380,12 @@ func (t *Transport) loop() {
 			// FRAME 0: sender
 			// FRAME 3: request ID (uint16; BE)
 			// FRAME 4: method (string)
-			// FRAME 5: method arguments (object; encoded with MessagePack)
+			// FRAME 5: method arguments (object; encoded with MessagePack; possibly compressed)
 			// FRAME 6: stdout stream tag (empty or uint16; BE)
 			// FRAME 7: stderr stream tag (empty of uint16; BE)
+			// FRAME 8: flags (optional; byte)
 			switch {
-			case len(msg) != 8:
+			case len(msg) != 8 && len(msg) != 9:
 				log.Warn("websocket<RPC>: REQUEST: invalid message length")
 				return
 			case len(msg[0]) == 0:
@@ -366,6 +403,9 @@ func (t *Transport) loop() {
 			case len(msg[7]) != 0 && len(msg[7]) != 2:
 				log.Warn("websocket<RPC>: REQUEST: invalid stdout tag frame received")
 				return
+			case len(msg) == 9 && len(msg[8]) != 1:
+				log.Warn("websocket<RPC>: REQUEST: invalid flags frame received")
+				return
 			}
 
 			req, err := t.newRequest(msg)
@@ -426,8 +466,9 @@ func (t *Transport) loop() {
 			// FRAME 0: empty
 			// FRAME 3: stream tag (uint16; BE)
 			// FRAME 4: frame payload (bytes)
+			// FRAME 5: flags (optional; byte)
 			switch {
-			case len(msg) != 5:
+			case len(msg) != 5 && len(msg) != 6:
 				log.Warn("websocket<RPC>: STREAMFRAME: invalid message length")
 				return
 			case len(msg[0]) != 0:
@@ -439,17 +480,26 @@ func (t *Transport) loop() {
 			case len(msg[4]) == 0:
 				log.Warn("websocket<RPC>: STREAMFRAME: empty frame received")
 				return
+			case len(msg) == 6 && len(msg[5]) != 1:
+				log.Warn("websocket<RPC>: STREAMFRAME: invalid flags frame received")
+				return
 			}
 
-			t.streamingCh <- newStreamFrame(msg)
+			frame, err := newStreamFrame(t, msg)
+			if err != nil {
+				log.Warnf("websocket<RPC>: STREAMFRAME: %v", err)
+				return
+			}
+			t.streamingCh <- frame
 
 		case MessageTypeReply:
 			// FRAME 0: empty
 			// FRAME 3: request ID (uint16; BE)
 			// FRAME 4: return code (byte)
-			// FRAME 5: return value (object; encoded with MessagePack)
+			// FRAME 5: return value (object; encoded with MessagePack; possibly compressed)
+			// FRAME 6: flags (optional; byte)
 			switch {
-			case len(msg) != 6:
+			case len(msg) != 6 && len(msg) != 7:
 				log.Warn("websocket<RPC>: REPLY: invalid message length")
 				return
 			case len(msg[0]) != 0:
@@ -461,9 +511,12 @@ func (t *Transport) loop() {
 			case len(msg[4]) != 1:
 				log.Warn("websocket<RPC>: REPLY: invalid return code frame received")
 				return
+			case len(msg) == 7 && len(msg[6]) != 1:
+				log.Warn("websocket<RPC>: REPLY: invalid flags frame received")
+				return
 			}
 
-			t.replyCh <- newReply(msg)
+			t.replyCh <- newReply(t, msg)
 
 		case MessageTypePing:
 			if err := frames.C.Send(t.conn, pongMessage); err != nil {
@@ -495,20 +548,28 @@ func (t *Transport) resolveRequest(req *remoteRequest, retCode rpc.ReturnCode, r
 	if err := codecs.MessagePack.Encode(&valueBuffer, retValue); err != nil {
 		return err
 	}
+	value, compressed, err := codecs.Compress(valueBuffer.Bytes(), t.compressionThreshold)
+	if err != nil {
+		return err
+	}
 
 	key := string(append(req.msg[0], req.msg[3]...))
 	t.requestsMu.Lock()
 	delete(t.incomingRequests, key)
 	t.requestsMu.Unlock()
 
-	return frames.C.Send(t.conn, [][]byte{
+	msg := [][]byte{
 		req.msg[0],
 		frameHeader,
 		frameReplyMT,
 		req.msg[3],
 		[]byte{byte(retCode)},
-		valueBuffer.Bytes(),
-	})
+		value,
+	}
+	if compressed {
+		msg = append(msg, frameFlagsCompressed)
+	}
+	return frames.C.Send(t.conn, msg)
 }
 
 // Errors ----------------------------------------------------------------------
@@ -0,0 +1,227 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package broker implements an embedded Meeko broker speaking CDR#RPC@01,
+// CDR#PUBSUB@01 and CDR#LOGGING@01 over ZeroMQ 3.x sockets. The ZeroMQ 3.x
+// transports from this repository can connect to it without any change.
+//
+// The broker is meant for integration tests and single-host deployments where
+// running meekod is not desirable. It does not implement any access control,
+// it does not ping the clients and it keeps all the state in memory.
+package broker
+
+import (
+	// Stdlib
+	"sync"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services"
+	"github.com/meeko/go-meeko/meeko/transports/zmq3/loop"
+
+	// Other
+	log "github.com/cihub/seelog"
+	"github.com/dmotylev/nutrition"
+	zmq "github.com/pebbe/zmq3"
+)
+
+type BrokerFactory struct {
+	// Endpoint for the RPC ROUTER socket.
+	RPCEndpoint string
+
+	// Endpoints for the PubSub ROUTER and PUB sockets.
+	PubSubRouterEndpoint string
+	PubSubPubEndpoint    string
+
+	// Endpoint for the Logging PULL socket.
+	LoggingEndpoint string
+
+	// Use 64-bit event sequence numbers. 32-bit sequence numbers are used
+	// by default, these wrap around.
+	PubSubSeqNum64 bool
+
+	RouterSndhwm int
+	RouterRcvhwm int
+	PubSndhwm    int
+	PullRcvhwm   int
+}
+
+func NewBrokerFactory() *BrokerFactory {
+	// Keep ZeroMQ defaults by default.
+	return &BrokerFactory{
+		RouterSndhwm: 1000,
+		RouterRcvhwm: 1000,
+		PubSndhwm:    1000,
+		PullRcvhwm:   1000,
+	}
+}
+
+func (factory *BrokerFactory) ReadConfigFromEnv(prefix string) error {
+	return nutrition.Env(prefix).Feed(factory)
+}
+
+func (factory *BrokerFactory) MustReadConfigFromEnv(prefix string) *BrokerFactory {
+	if err := factory.ReadConfigFromEnv(prefix); err != nil {
+		panic(err)
+	}
+	return factory
+}
+
+func (factory *BrokerFactory) IsFullyConfigured() error {
+	if factory.RPCEndpoint == "" {
+		return &services.ErrMissingConfig{"RPC endpoint", "ZeroMQ 3.x broker"}
+	}
+	if factory.PubSubRouterEndpoint == "" {
+		return &services.ErrMissingConfig{"PubSub ROUTER endpoint", "ZeroMQ 3.x broker"}
+	}
+	if factory.PubSubPubEndpoint == "" {
+		return &services.ErrMissingConfig{"PubSub PUB endpoint", "ZeroMQ 3.x broker"}
+	}
+	if factory.LoggingEndpoint == "" {
+		return &services.ErrMissingConfig{"Logging endpoint", "ZeroMQ 3.x broker"}
+	}
+	return nil
+}
+
+func (factory *BrokerFactory) MustBeFullyConfigured() *BrokerFactory {
+	if err := factory.IsFullyConfigured(); err != nil {
+		panic(err)
+	}
+	return factory
+}
+
+// Broker represents a running broker instance.
+type Broker struct {
+	messageLoop *loop.MessageLoop
+	pub         *zmq.Socket
+
+	rpc     *rpcExchange
+	pubsub  *pubsubExchange
+	logging *logCollector
+
+	closeOnce *sync.Once
+	closedCh  chan struct{}
+	err       error
+}
+
+// NewBroker binds all the sockets and starts serving the clients.
+//
+// logHandler is called for every log record received. The records are logged
+// using seelog when logHandler is nil.
+func (factory *BrokerFactory) NewBroker(logHandler LogHandler) (*Broker, error) {
+	// Make sure the config is complete.
+	if err := factory.IsFullyConfigured(); err != nil {
+		return nil, err
+	}
+
+	var sockets []*zmq.Socket
+	closeAll := func() {
+		for _, sock := range sockets {
+			sock.Close()
+		}
+	}
+
+	newSocket := func(typ zmq.Type, endpoint string, sndhwm, rcvhwm int) (*zmq.Socket, error) {
+		sock, err := zmq.NewSocket(typ)
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, sock)
+
+		if sndhwm != 0 {
+			if err := sock.SetSndhwm(sndhwm); err != nil {
+				return nil, err
+			}
+		}
+		if rcvhwm != 0 {
+			if err := sock.SetRcvhwm(rcvhwm); err != nil {
+				return nil, err
+			}
+		}
+		if err := sock.Bind(endpoint); err != nil {
+			return nil, err
+		}
+		return sock, nil
+	}
+
+	// RPC ROUTER socket
+	rpcRouter, err := newSocket(zmq.ROUTER, factory.RPCEndpoint,
+		factory.RouterSndhwm, factory.RouterRcvhwm)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	// PubSub ROUTER socket
+	pubsubRouter, err := newSocket(zmq.ROUTER, factory.PubSubRouterEndpoint,
+		factory.RouterSndhwm, factory.RouterRcvhwm)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	// PubSub PUB socket
+	pub, err := newSocket(zmq.PUB, factory.PubSubPubEndpoint, factory.PubSndhwm, 0)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	// Logging PULL socket
+	pull, err := newSocket(zmq.PULL, factory.LoggingEndpoint, 0, factory.PullRcvhwm)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	broker := &Broker{
+		pub:       pub,
+		rpc:       newRPCExchange(rpcRouter),
+		pubsub:    newPubSubExchange(pubsubRouter, pub, factory.PubSubSeqNum64),
+		logging:   newLogCollector(logHandler),
+		closeOnce: new(sync.Once),
+		closedCh:  make(chan struct{}),
+	}
+
+	items := loop.PollItems{
+		{rpcRouter, broker.rpc.handleMessage},
+		{pubsubRouter, broker.pubsub.handleMessage},
+		{pull, broker.logging.handleMessage},
+	}
+
+	broker.messageLoop, err = loop.New(items, loop.CommandHandlers{})
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	log.Debug("zmq3<Broker>: Serving")
+	return broker, nil
+}
+
+// Close terminates the broker and closes all the sockets.
+func (broker *Broker) Close() error {
+	broker.closeOnce.Do(func() {
+		log.Debug("zmq3<Broker>: Terminating")
+		broker.err = broker.messageLoop.Terminate()
+		// The PUB socket is not a poll item, so it is not closed by the loop.
+		if err := broker.pub.Close(); err != nil && broker.err == nil {
+			broker.err = err
+		}
+		close(broker.closedCh)
+		log.Debug("zmq3<Broker>: Terminated")
+	})
+	return broker.err
+}
+
+// Closed returns a channel that is closed once the broker is terminated.
+func (broker *Broker) Closed() <-chan struct{} {
+	return broker.closedCh
+}
+
+// Wait blocks until the broker is terminated.
+func (broker *Broker) Wait() error {
+	<-broker.Closed()
+	return broker.err
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package broker
+
+import (
+	// Stdlib
+	"bytes"
+
+	// Meeko
+	zlogging "github.com/meeko/go-meeko/meeko/transports/zmq3/logging"
+
+	// Other
+	log "github.com/cihub/seelog"
+)
+
+var frameLoggingHeader = []byte("CDR#LOGGING@01")
+
+// LogRecord represents a log record received from an app.
+type LogRecord struct {
+	Source  string
+	Level   zlogging.LogLevel
+	Message string
+}
+
+type LogHandler func(record *LogRecord)
+
+type logCollector struct {
+	handler LogHandler
+}
+
+func newLogCollector(handler LogHandler) *logCollector {
+	if handler == nil {
+		handler = logRecord
+	}
+	return &logCollector{handler}
+}
+
+func (collector *logCollector) handleMessage(msg [][]byte) {
+	// FRAME 0: source identity (string)
+	// FRAME 1: message header (string)
+	// FRAME 2: log level (byte)
+	// FRAME 3: log message (string)
+	switch {
+	case len(msg) != 4:
+		log.Warn("zmq3<Broker>: Logging: Invalid message length")
+		return
+	case !bytes.Equal(msg[1], frameLoggingHeader):
+		log.Warn("zmq3<Broker>: Logging: Invalid message header")
+		return
+	case len(msg[2]) != 1:
+		log.Warn("zmq3<Broker>: Logging: Invalid log level")
+		return
+	}
+
+	collector.handler(&LogRecord{
+		Source:  string(msg[0]),
+		Level:   zlogging.LogLevel(msg[2][0]),
+		Message: string(msg[3]),
+	})
+}
+
+func logRecord(record *LogRecord) {
+	switch record.Level {
+	case zlogging.LevelTrace:
+		log.Tracef("<%v> %v", record.Source, record.Message)
+	case zlogging.LevelDebug:
+		log.Debugf("<%v> %v", record.Source, record.Message)
+	case zlogging.LevelInfo:
+		log.Infof("<%v> %v", record.Source, record.Message)
+	case zlogging.LevelWarn:
+		log.Warnf("<%v> %v", record.Source, record.Message)
+	case zlogging.LevelError:
+		log.Errorf("<%v> %v", record.Source, record.Message)
+	case zlogging.LevelCritical:
+		log.Criticalf("<%v> %v", record.Source, record.Message)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package broker
+
+import (
+	// Stdlib
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+
+	// Other
+	log "github.com/cihub/seelog"
+	zmq "github.com/pebbe/zmq3"
+)
+
+const (
+	messageTypeEvent byte = iota
+	messageTypeEventSeqTable
+	messageTypeEventAck
+)
+
+var (
+	framePubSubHeader = []byte("CDR#PUBSUB@01")
+
+	frameEventType         = []byte{messageTypeEvent}
+	frameEventSeqTableType = []byte{messageTypeEventSeqTable}
+	frameEventAckType      = []byte{messageTypeEventAck}
+)
+
+// pubsubExchange assigns sequence numbers to the events being published and
+// forwards them to the subscribers. It is only accessed from within the broker
+// message loop, so it needs no locking.
+type pubsubExchange struct {
+	router *zmq.Socket
+	pub    *zmq.Socket
+
+	seqNums  map[string]pubsub.EventSeqNum
+	seqNum64 bool
+}
+
+func newPubSubExchange(router, pub *zmq.Socket, seqNum64 bool) *pubsubExchange {
+	return &pubsubExchange{
+		router:   router,
+		pub:      pub,
+		seqNums:  make(map[string]pubsub.EventSeqNum),
+		seqNum64: seqNum64,
+	}
+}
+
+func (ex *pubsubExchange) handleMessage(msg [][]byte) {
+	// Check the message header frames.
+	//
+	// FRAME 0: sender identity (string)
+	// FRAME 1: event kind or event kind prefix (string)
+	// FRAME 2: message header (string)
+	// FRAME 3: message type (byte)
+	switch {
+	case len(msg) < 4:
+		log.Warn("zmq3<Broker>: PubSub: Message too short")
+		return
+	case !bytes.Equal(msg[2], framePubSubHeader):
+		log.Warn("zmq3<Broker>: PubSub: Invalid message header")
+		return
+	}
+
+	switch {
+	case bytes.Equal(msg[3], frameEventType):
+		// FRAME 4: acknowledgement token (bytes; empty when not requested)
+		// FRAME 5: event object (bytes)
+		// FRAME 6: event properties (optional; passed through)
+		// FRAME 7: flags (optional; passed through)
+		if len(msg) < 6 || len(msg) > 8 || len(msg[1]) == 0 {
+			log.Warn("zmq3<Broker>: PubSub: EVENT: invalid message")
+			return
+		}
+		seq := ex.publish(msg[0], msg[1], msg[5:])
+		if len(msg[4]) != 0 {
+			ex.sendAck(msg[0], msg[1], msg[4], seq)
+		}
+
+	case bytes.Equal(msg[3], frameEventSeqTableType):
+		if len(msg) != 4 {
+			log.Warn("zmq3<Broker>: PubSub: SEQTABLE: invalid message")
+			return
+		}
+		ex.sendSeqTable(msg[0], string(msg[1]))
+
+	default:
+		log.Warn("zmq3<Broker>: PubSub: Invalid message type")
+	}
+}
+
+// publish forwards the event to the subscribers. payload contains the event
+// object frame, optionally followed by the event properties and flags frames.
+// The sequence number assigned to the event is returned.
+func (ex *pubsubExchange) publish(publisher, kind []byte, payload [][]byte) pubsub.EventSeqNum {
+	seq := ex.seqNums[string(kind)] + 1
+	if !ex.seqNum64 {
+		// Let the 32-bit sequence numbers wrap around.
+		seq = pubsub.EventSeqNum(uint32(seq))
+	}
+	ex.seqNums[string(kind)] = seq
+
+	msg := [][]byte{
+		kind,
+		publisher,
+		framePubSubHeader,
+		frameEventType,
+		ex.encodeSeq(seq),
+	}
+	if _, err := ex.pub.SendMessage(append(msg, payload...)); err != nil {
+		log.Warnf("zmq3<Broker>: PubSub: Failed to publish %v: %v", string(kind), err)
+	}
+	return seq
+}
+
+// sendAck tells the publisher what sequence number was assigned to the event
+// published together with token.
+func (ex *pubsubExchange) sendAck(publisher, kind, token []byte, seq pubsub.EventSeqNum) {
+	msg := [][]byte{
+		publisher,
+		framePubSubHeader,
+		frameEventAckType,
+		kind,
+		token,
+		ex.encodeSeq(seq),
+	}
+	if _, err := ex.router.SendMessage(msg); err != nil {
+		log.Warnf("zmq3<Broker>: PubSub: Failed to send ACK to %v: %v", string(publisher), err)
+	}
+}
+
+func (ex *pubsubExchange) sendSeqTable(receiver []byte, kindPrefix string) {
+	msg := [][]byte{
+		receiver,
+		framePubSubHeader,
+		frameEventSeqTableType,
+	}
+
+	for kind, seq := range ex.seqNums {
+		if !strings.HasPrefix(kind, kindPrefix) {
+			continue
+		}
+
+		msg = append(msg, []byte(kind), ex.encodeSeq(seq))
+	}
+
+	if _, err := ex.router.SendMessage(msg); err != nil {
+		log.Warnf("zmq3<Broker>: PubSub: Failed to send SEQTABLE to %v: %v", string(receiver), err)
+	}
+}
+
+// encodeSeq encodes seq as uint32 or uint64, depending on the configuration.
+func (ex *pubsubExchange) encodeSeq(seq pubsub.EventSeqNum) []byte {
+	if ex.seqNum64 {
+		frame := make([]byte, 8)
+		binary.BigEndian.PutUint64(frame, uint64(seq))
+		return frame
+	}
+	frame := make([]byte, 4)
+	binary.BigEndian.PutUint32(frame, uint32(seq))
+	return frame
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package broker
+
+import (
+	// Stdlib
+	"bytes"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/rpc"
+	zrpc "github.com/meeko/go-meeko/meeko/transports/zmq3/rpc"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+
+	// Other
+	log "github.com/cihub/seelog"
+	zmq "github.com/pebbe/zmq3"
+)
+
+// Return codes used for the replies generated by the broker itself.
+const (
+	ReturnCodeMethodNotFound rpc.ReturnCode = 252
+	ReturnCodeExecutorGone   rpc.ReturnCode = 253
+)
+
+var (
+	frameEmpty     = []byte{}
+	frameRPCHeader = []byte(zrpc.Header)
+
+	frameRequestMT     = []byte{zrpc.MessageTypeRequest}
+	frameInterruptMT   = []byte{zrpc.MessageTypeInterrupt}
+	frameProgressMT    = []byte{zrpc.MessageTypeProgress}
+	frameStreamFrameMT = []byte{zrpc.MessageTypeStreamFrame}
+	frameReplyMT       = []byte{zrpc.MessageTypeReply}
+)
+
+type pendingRequest struct {
+	caller   string
+	executor string
+	id       []byte
+}
+
+// rpcExchange routes RPC messages between the connected apps. It is only
+// accessed from within the broker message loop, so it needs no locking.
+type rpcExchange struct {
+	router *zmq.Socket
+
+	// method -> identities of the apps exporting the method
+	executors map[string][]string
+	// method -> index of the next executor to be used
+	next map[string]int
+	// caller + request ID -> request
+	requests map[string]*pendingRequest
+}
+
+func newRPCExchange(router *zmq.Socket) *rpcExchange {
+	return &rpcExchange{
+		router:    router,
+		executors: make(map[string][]string),
+		next:      make(map[string]int),
+		requests:  make(map[string]*pendingRequest),
+	}
+}
+
+func (ex *rpcExchange) handleMessage(msg [][]byte) {
+	// Check the message header frames.
+	//
+	// FRAME 0: sender identity (string)
+	// FRAME 1: empty or receiver (string)
+	// FRAME 2: message header (string)
+	// FRAME 3: message type (byte)
+	switch {
+	case len(msg) < 4:
+		log.Warn("zmq3<Broker>: RPC: Message too short")
+		return
+	case !bytes.Equal(msg[2], frameRPCHeader):
+		log.Warn("zmq3<Broker>: RPC: Invalid message header")
+		return
+	case len(msg[3]) != 1:
+		log.Warn("zmq3<Broker>: RPC: Invalid message type")
+		return
+	}
+
+	sender := string(msg[0])
+
+	switch msg[3][0] {
+	case zrpc.MessageTypeRegister:
+		// FRAME 4: method (string)
+		if len(msg) != 5 || len(msg[4]) == 0 {
+			log.Warn("zmq3<Broker>: RPC: REGISTER: invalid message")
+			return
+		}
+		ex.register(sender, string(msg[4]))
+
+	case zrpc.MessageTypeUnregister:
+		// FRAME 4: method (string)
+		if len(msg) != 5 || len(msg[4]) == 0 {
+			log.Warn("zmq3<Broker>: RPC: UNREGISTER: invalid message")
+			return
+		}
+		ex.unregister(sender, string(msg[4]))
+
+	case zrpc.MessageTypeRequest:
+		// FRAME 4: request ID (uint16; BE)
+		// FRAME 5: method (string)
+		// FRAME 6: method arguments (object; encoded with MessagePack)
+		// FRAME 7: stdout stream tag (empty or uint16; BE)
+		// FRAME 8: stderr stream tag (empty or uint16; BE)
+		// FRAME 9: flags (optional; byte)
+		if (len(msg) != 9 && len(msg) != 10) || len(msg[4]) != 2 || len(msg[5]) == 0 {
+			log.Warn("zmq3<Broker>: RPC: REQUEST: invalid message")
+			return
+		}
+		ex.handleRequest(sender, msg)
+
+	case zrpc.MessageTypeInterrupt:
+		// FRAME 4: request ID (uint16; BE)
+		if len(msg) != 5 || len(msg[4]) != 2 {
+			log.Warn("zmq3<Broker>: RPC: INTERRUPT: invalid message")
+			return
+		}
+
+		req, ok := ex.requests[sender+string(msg[4])]
+		if !ok {
+			return
+		}
+		ex.send([][]byte{
+			[]byte(req.executor),
+			[]byte(req.caller),
+			frameRPCHeader,
+			frameInterruptMT,
+			req.id,
+		})
+
+	case zrpc.MessageTypeProgress:
+		// FRAME 1: receiver (string)
+		// FRAME 4: request ID (uint16; BE)
+		if len(msg) != 5 || len(msg[1]) == 0 || len(msg[4]) != 2 {
+			log.Warn("zmq3<Broker>: RPC: PROGRESS: invalid message")
+			return
+		}
+
+		req, ok := ex.requests[string(msg[1])+string(msg[4])]
+		if !ok || req.executor != sender {
+			return
+		}
+		ex.send([][]byte{
+			msg[1],
+			frameEmpty,
+			frameRPCHeader,
+			frameProgressMT,
+			msg[4],
+		})
+
+	case zrpc.MessageTypeStreamFrame:
+		// FRAME 1: receiver (string)
+		// FRAME 4: stream tag (uint16; BE)
+		// FRAME 5: frame payload (bytes)
+		// FRAME 6: flags (optional; byte)
+		if (len(msg) != 6 && len(msg) != 7) || len(msg[1]) == 0 || len(msg[4]) != 2 {
+			log.Warn("zmq3<Broker>: RPC: STREAMFRAME: invalid message")
+			return
+		}
+		ex.send(append([][]byte{
+			msg[1],
+			frameEmpty,
+			frameRPCHeader,
+			frameStreamFrameMT,
+		}, msg[4:]...))
+
+	case zrpc.MessageTypeReply:
+		// FRAME 1: receiver (string)
+		// FRAME 4: request ID (uint16; BE)
+		// FRAME 5: return code (byte)
+		// FRAME 6: return value (object; encoded with MessagePack)
+		// FRAME 7: flags (optional; byte)
+		if (len(msg) != 7 && len(msg) != 8) || len(msg[1]) == 0 || len(msg[4]) != 2 || len(msg[5]) != 1 {
+			log.Warn("zmq3<Broker>: RPC: REPLY: invalid message")
+			return
+		}
+
+		key := string(msg[1]) + string(msg[4])
+		req, ok := ex.requests[key]
+		if !ok || req.executor != sender {
+			return
+		}
+		delete(ex.requests, key)
+		ex.send(append([][]byte{
+			msg[1],
+			frameEmpty,
+			frameRPCHeader,
+			frameReplyMT,
+		}, msg[4:]...))
+
+	case zrpc.MessageTypePong:
+
+	case zrpc.MessageTypeKthxbye:
+		ex.dropApp(sender)
+
+	default:
+		log.Warn("zmq3<Broker>: RPC: Unknown message type received")
+	}
+}
+
+func (ex *rpcExchange) register(app, method string) {
+	for _, executor := range ex.executors[method] {
+		if executor == app {
+			return
+		}
+	}
+	log.Debugf("zmq3<Broker>: RPC: %v registered %q", app, method)
+	ex.executors[method] = append(ex.executors[method], app)
+}
+
+func (ex *rpcExchange) unregister(app, method string) {
+	executors := ex.executors[method]
+	for i, executor := range executors {
+		if executor == app {
+			executors = append(executors[:i], executors[i+1:]...)
+			break
+		}
+	}
+
+	if len(executors) == 0 {
+		delete(ex.executors, method)
+		delete(ex.next, method)
+		return
+	}
+	ex.executors[method] = executors
+}
+
+func (ex *rpcExchange) handleRequest(caller string, msg [][]byte) {
+	key := caller + string(msg[4])
+	if _, ok := ex.requests[key]; ok {
+		log.Warnf("zmq3<Broker>: RPC: REQUEST: duplicate request ID received from %v", caller)
+		return
+	}
+
+	method := string(msg[5])
+	executors := ex.executors[method]
+	if len(executors) == 0 {
+		ex.reply(caller, msg[4], ReturnCodeMethodNotFound, "method not found")
+		return
+	}
+
+	// Pick the executor in a round-robin fashion.
+	next := ex.next[method] % len(executors)
+	ex.next[method] = next + 1
+	executor := executors[next]
+
+	ex.requests[key] = &pendingRequest{caller, executor, msg[4]}
+	ex.send(append([][]byte{
+		[]byte(executor),
+		[]byte(caller),
+		frameRPCHeader,
+		frameRequestMT,
+	}, msg[4:]...))
+}
+
+// dropApp cleans up after an app that has disconnected. The requests being
+// handled by the app are resolved by the broker, the requests sent by the app
+// are interrupted.
+func (ex *rpcExchange) dropApp(app string) {
+	log.Debugf("zmq3<Broker>: RPC: %v disconnected", app)
+
+	for method := range ex.executors {
+		ex.unregister(app, method)
+	}
+
+	for key, req := range ex.requests {
+		switch app {
+		case req.executor:
+			delete(ex.requests, key)
+			ex.reply(req.caller, req.id, ReturnCodeExecutorGone, "executor disconnected")
+		case req.caller:
+			delete(ex.requests, key)
+			ex.send([][]byte{
+				[]byte(req.executor),
+				[]byte(req.caller),
+				frameRPCHeader,
+				frameInterruptMT,
+				req.id,
+			})
+		}
+	}
+}
+
+func (ex *rpcExchange) reply(receiver string, id []byte, code rpc.ReturnCode, value interface{}) {
+	var valueBuffer bytes.Buffer
+	if err := codecs.MessagePack.Encode(&valueBuffer, value); err != nil {
+		panic(err)
+	}
+
+	ex.send([][]byte{
+		[]byte(receiver),
+		frameEmpty,
+		frameRPCHeader,
+		frameReplyMT,
+		id,
+		[]byte{byte(code)},
+		valueBuffer.Bytes(),
+	})
+}
+
+func (ex *rpcExchange) send(msg [][]byte) {
+	if _, err := ex.router.SendMessage(msg); err != nil {
+		log.Warnf("zmq3<Broker>: RPC: Failed to send a message to %v: %v", string(msg[0]), err)
+	}
+}
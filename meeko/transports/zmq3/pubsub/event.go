@@ -8,6 +8,9 @@ package pubsub
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/meeko/go-meeko/meeko/services/pubsub"
 	"github.com/meeko/go-meeko/meeko/utils/codecs"
@@ -17,21 +20,44 @@ type Event struct {
 	kind      string
 	seq       pubsub.EventSeqNum
 	publisher string
+	props     pubsub.EventProps
 	body      []byte
 }
 
-func newEvent(msg [][]byte) pubsub.Event {
-	var seq pubsub.EventSeqNum
+func newEvent(msg [][]byte, maxDecompressedSize int) (pubsub.Event, error) {
 	// The message should be validated by the time it gets here. Panic on error.
-	if err := binary.Read(bytes.NewReader(msg[4]), binary.BigEndian, &seq); err != nil {
+	seq, err := decodeSeq(msg[4])
+	if err != nil {
 		panic(err)
 	}
-	return &Event{
+
+	// The event object can be compressed, see TransportFactory.
+	body := msg[5]
+	if len(msg) == 8 && msg[7][0]&eventFlagCompressed != 0 {
+		body, err = codecs.Decompress(body, maxDecompressedSize)
+		if err != nil {
+			return nil, &ErrInvalidEvent{"event object", err}
+		}
+	}
+
+	event := &Event{
 		kind:      string(msg[0]),
 		seq:       seq,
 		publisher: string(msg[1]),
-		body:      msg[5],
+		body:      body,
+	}
+
+	// The event properties frame is optional, older publishers don't send it.
+	// It is empty when only the flags frame is needed.
+	if len(msg) >= 7 && len(msg[6]) != 0 {
+		props, err := decodeEventProps(msg[6])
+		if err != nil {
+			return nil, &ErrInvalidEvent{"event properties", err}
+		}
+		event.props = *props
 	}
+
+	return event, nil
 }
 
 func (event *Event) Kind() string {
@@ -46,6 +72,87 @@ func (event *Event) Publisher() string {
 	return event.publisher
 }
 
+func (event *Event) Id() string {
+	return event.props.Id
+}
+
+func (event *Event) Timestamp() time.Time {
+	return event.props.Timestamp
+}
+
+func (event *Event) Headers() pubsub.EventHeaders {
+	return event.props.Headers
+}
+
 func (event *Event) Unmarshal(dst interface{}) error {
 	return codecs.MessagePack.Decode(bytes.NewReader(event.body), dst)
 }
+
+// Sequence numbers ------------------------------------------------------------
+
+// decodeSeq decodes an event sequence number, which is either uint32 or
+// uint64, depending on the broker configuration.
+func decodeSeq(frame []byte) (pubsub.EventSeqNum, error) {
+	switch len(frame) {
+	case 4:
+		return pubsub.EventSeqNum(binary.BigEndian.Uint32(frame)), nil
+	case 8:
+		return pubsub.EventSeqNum(binary.BigEndian.Uint64(frame)), nil
+	default:
+		return 0, ErrInvalidSeq
+	}
+}
+
+// Event properties ------------------------------------------------------------
+
+// eventProps is the wire format of pubsub.EventProps.
+type eventProps struct {
+	Id        string
+	Timestamp int64
+	Headers   map[string]string
+}
+
+func encodeEventProps(props *pubsub.EventProps) ([]byte, error) {
+	wire := &eventProps{
+		Id:      props.Id,
+		Headers: props.Headers,
+	}
+	if !props.Timestamp.IsZero() {
+		wire.Timestamp = props.Timestamp.UnixNano()
+	}
+
+	var buf bytes.Buffer
+	if err := codecs.MessagePack.Encode(&buf, wire); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeEventProps(frame []byte) (*pubsub.EventProps, error) {
+	var wire eventProps
+	if err := codecs.MessagePack.Decode(bytes.NewReader(frame), &wire); err != nil {
+		return nil, err
+	}
+
+	props := &pubsub.EventProps{
+		Id:      wire.Id,
+		Headers: pubsub.EventHeaders(wire.Headers),
+	}
+	if wire.Timestamp != 0 {
+		props.Timestamp = time.Unix(0, wire.Timestamp)
+	}
+	return props, nil
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrInvalidSeq = errors.New("invalid event sequence number")
+
+type ErrInvalidEvent struct {
+	Frame string
+	Err   error
+}
+
+func (err *ErrInvalidEvent) Error() string {
+	return fmt.Sprintf("invalid %v: %v", err.Frame, err.Err)
+}
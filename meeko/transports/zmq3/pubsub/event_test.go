@@ -0,0 +1,111 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	// Stdlib
+	"bytes"
+	"testing"
+	"time"
+
+	// Meeko
+	"github.com/meeko/go-meeko/meeko/services/pubsub"
+	"github.com/meeko/go-meeko/meeko/utils/codecs"
+)
+
+func TestNewEvent(t *testing.T) {
+	object := string(bytes.Repeat([]byte("meeko"), 100))
+
+	var buf bytes.Buffer
+	if err := codecs.MessagePack.Encode(&buf, object); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	compressed, ok, err := codecs.Compress(plain, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the event object was not compressed")
+	}
+
+	props := &pubsub.EventProps{
+		Id:        "id",
+		Timestamp: time.Unix(0, 1),
+		Headers:   pubsub.EventHeaders{"trace": "1"},
+	}
+	propsFrame, err := encodeEventProps(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frames following the event object frame.
+	cases := []struct {
+		name   string
+		object []byte
+		tail   [][]byte
+		max    int
+		props  bool
+		err    error
+	}{
+		{"no properties and flags frames", plain, nil, 0, false, nil},
+		{"no flags frame", plain, [][]byte{propsFrame}, 0, true, nil},
+		{"flags not set", plain, [][]byte{propsFrame, {0}}, 0, true, nil},
+		{"compressed", compressed, [][]byte{frameEmpty, frameEventCompressed}, 0, false, nil},
+		{"compressed with properties", compressed, [][]byte{propsFrame, frameEventCompressed}, 0, true, nil},
+		{"compressed at the limit", compressed, [][]byte{frameEmpty, frameEventCompressed}, len(plain), false, nil},
+		{"compressed over the limit", compressed, [][]byte{frameEmpty, frameEventCompressed}, len(plain) - 1, false,
+			codecs.ErrPayloadTooLarge},
+	}
+
+	for _, c := range cases {
+		msg := append([][]byte{
+			[]byte("a"),
+			[]byte("publisher"),
+			frameHeader,
+			frameEventType,
+			{0, 0, 0, 1},
+			c.object,
+		}, c.tail...)
+
+		event, err := newEvent(msg, c.max)
+		if c.err != nil {
+			if ierr, ok := err.(*ErrInvalidEvent); !ok || ierr.Err != c.err {
+				t.Errorf("%v: expected error %v, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		if event.Kind() != "a" || event.Seq() != 1 || event.Publisher() != "publisher" {
+			t.Errorf("%v: unexpected event: %+v", c.name, event)
+		}
+		var dst string
+		if err := event.Unmarshal(&dst); err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if dst != object {
+			t.Errorf("%v: the event object does not match", c.name)
+		}
+
+		if !c.props {
+			if event.Id() != "" || !event.Timestamp().IsZero() || event.Headers() != nil {
+				t.Errorf("%v: expected no properties, got %+v", c.name, event)
+			}
+			continue
+		}
+		if event.Id() != props.Id || !event.Timestamp().Equal(props.Timestamp) ||
+			event.Headers()["trace"] != "1" {
+
+			t.Errorf("%v: unexpected properties: %+v", c.name, event)
+		}
+	}
+}
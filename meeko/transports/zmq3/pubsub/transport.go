@@ -28,6 +28,19 @@ type TransportFactory struct {
 	DealerRcvhwm   int
 	PubEndpoint    string
 	SubRcvhwm      int
+
+	// Event objects encoded to at least this many bytes are compressed,
+	// zero meaning that compression is disabled. Compressed events are
+	// decompressed transparently no matter what the threshold is.
+	//
+	// Compressed events carry an extra flags frame, so the broker must
+	// support it when compression is enabled. Subscribers that do not know
+	// the flags frame drop the compressed events.
+	CompressionThreshold int
+
+	// Compressed events decompressing to more than this many bytes are
+	// dropped, zero meaning codecs.DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
 }
 
 func NewTransportFactory() *TransportFactory {
@@ -68,6 +81,8 @@ func (factory *TransportFactory) MustBeFullyConfigured() *TransportFactory {
 }
 
 type Transport struct {
+	identity string
+
 	// Internal channels
 	cmdCh      chan *command
 	routerCh   chan [][]byte
@@ -78,8 +93,18 @@ type Transport struct {
 	// Output interface for the Service using this Transport.
 	eventCh chan pubsub.Event
 	tableCh chan pubsub.EventSeqTable
+	ackCh   chan *pubsub.PublishedEvent
 	errorCh chan error
 
+	// The events waiting for the broker to acknowledge them, oldest first.
+	// Only accessed from within the internal message loop.
+	pendingAcks []*pendingAck
+	lastToken   uint64
+
+	// Compression settings, see TransportFactory.
+	compressionThreshold int
+	maxDecompressedSize  int
+
 	// Error to return from Wait.
 	err error
 }
@@ -137,6 +162,7 @@ func (factory *TransportFactory) NewTransport(identity string) (*Transport, erro
 
 	// Transport
 	t := &Transport{
+		identity:   identity,
 		cmdCh:      make(chan *command, 1),
 		routerCh:   make(chan [][]byte),
 		abortCh:    make(chan error, 1),
@@ -144,7 +170,11 @@ func (factory *TransportFactory) NewTransport(identity string) (*Transport, erro
 		closeAckCh: make(chan struct{}),
 		eventCh:    make(chan pubsub.Event),
 		tableCh:    make(chan pubsub.EventSeqTable),
+		ackCh:      make(chan *pubsub.PublishedEvent),
 		errorCh:    make(chan error),
+
+		compressionThreshold: factory.CompressionThreshold,
+		maxDecompressedSize:  factory.MaxDecompressedSize,
 	}
 
 	go t.loop(dealer, sub)
@@ -162,11 +192,13 @@ const (
 type publishArgs struct {
 	eventKind   string
 	eventObject interface{}
+	props       *pubsub.EventProps
+	acked       bool
 }
 
-func (t *Transport) Publish(eventKind string, eventObject interface{}) error {
+func (t *Transport) Publish(eventKind string, eventObject interface{}, props *pubsub.EventProps) error {
 	log.Debug("zmq3<PubSub>: Publish called")
-	return t.exec(cmdPublish, &publishArgs{eventKind, eventObject})
+	return t.exec(cmdPublish, &publishArgs{eventKind, eventObject, props, false})
 }
 
 func (t *Transport) Subscribe(eventKindPrefix string) error {
@@ -191,6 +223,17 @@ func (t *Transport) ErrorChan() <-chan error {
 	return t.errorCh
 }
 
+// pubsub.AckingTransport interface --------------------------------------------
+
+func (t *Transport) PublishAcked(eventKind string, eventObject interface{}, props *pubsub.EventProps) error {
+	log.Debug("zmq3<PubSub>: PublishAcked called")
+	return t.exec(cmdPublish, &publishArgs{eventKind, eventObject, props, true})
+}
+
+func (t *Transport) AckChan() <-chan *pubsub.PublishedEvent {
+	return t.ackCh
+}
+
 func (t *Transport) Close() (err error) {
 	errCh := make(chan error, 1)
 	select {
@@ -237,18 +280,36 @@ func (t *Transport) exec(cmdType int, cmdArgs interface{}) (err error) {
 const (
 	messageTypeEvent byte = iota
 	messageTypeEventSeqTable
+	messageTypeEventAck
 )
 
 const maxMessageType = messageTypeEvent
 
+// Flags that can be sent in the optional last frame of EVENT messages.
+const (
+	eventFlagCompressed byte = 1 << iota
+)
+
 var (
 	frameEmpty  = []byte{}
 	frameHeader = []byte("CDR#PUBSUB@01")
 
 	frameEventType         = []byte{messageTypeEvent}
 	frameEventSeqTableType = []byte{messageTypeEventSeqTable}
+	frameEventAckType      = []byte{messageTypeEventAck}
+
+	frameEventCompressed = []byte{eventFlagCompressed}
 )
 
+// maxPendingAcks is the number of events waiting for acknowledgement kept
+// at most, the oldest ones are forgotten when the limit is reached.
+const maxPendingAcks = 1000
+
+type pendingAck struct {
+	token uint64
+	event *pubsub.PublishedEvent
+}
+
 func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 	items := loop.PollItems{
 		{
@@ -257,9 +318,8 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				// Make sure that the message valid.
 				// Drop it if that is not the case.
 				//
-				// FRAME 0:        message header
-				// FRAME 1:        message type
-				// FRAME 2-(2k+2): event sequence numbers
+				// FRAME 0: message header
+				// FRAME 1: message type
 				switch {
 				case len(msg) < 2:
 					log.Warn("zmq3<PubSub>: Message too short")
@@ -267,10 +327,16 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				case !bytes.Equal(msg[0], frameHeader):
 					log.Warn("zmq3<PubSub>: Invalid message header")
 					return
+				case bytes.Equal(msg[1], frameEventAckType):
+					t.handleAck(msg)
+					return
 				case !bytes.Equal(msg[1], frameEventSeqTableType):
 					log.Warn("zmq3<PubSub>: Invalid message type")
 					return
-				case len(msg)%2 != 0:
+				}
+
+				// FRAME 2-(2k+2): event sequence numbers
+				if len(msg)%2 != 0 {
 					log.Warn("zmq3<PubSub>: Invalid message length")
 					return
 				}
@@ -280,9 +346,10 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				table := make(map[string]pubsub.EventSeqNum, len(msg)-2)
 				for i := 2; i < len(msg); i += 2 {
 					// msg[i]   - event kind
-					// msg[i+1] - event sequence number, uint32, BE
-					var seq pubsub.EventSeqNum
-					if err := binary.Read(bytes.NewReader(msg[i+1]), binary.BigEndian, &seq); err != nil {
+					// msg[i+1] - event sequence number, uint32 or uint64, BE
+					seq, err := decodeSeq(msg[i+1])
+					if err != nil {
+						log.Warn("zmq3<PubSub>: Invalid event sequence number")
 						return
 					}
 					table[string(msg[i])] = seq
@@ -301,11 +368,13 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				// FRAME 1: publisher (string)
 				// FRAME 2: message header (string)
 				// FRAME 3: message type (byte)
-				// FRAME 4: event sequence number (uint32, BE)
-				// FRAME 5: event object (bytes)
+				// FRAME 4: event sequence number (uint32 or uint64, BE)
+				// FRAME 5: event object (bytes; possibly compressed)
+				// FRAME 6: event properties (optional; empty or encoded with MessagePack)
+				// FRAME 7: flags (optional; byte)
 				switch {
-				case len(msg) != 6:
-					log.Warn("zmq3<PubSub>: Message dropped: event message too short")
+				case len(msg) < 6 || len(msg) > 8:
+					log.Warn("zmq3<PubSub>: Message dropped: invalid event message length")
 					return
 				case len(msg[0]) == 0:
 					log.Warn("zmq3<PubSub>: Message dropped: event kind not set")
@@ -319,15 +388,24 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				case !bytes.Equal(msg[3], frameEventType):
 					log.Warn("zmq3<PubSub>: Message dropped: invalid message type")
 					return
-				case len(msg[4]) != 4: // XXX: Hardcoded len, no good.
+				case len(msg[4]) != 4 && len(msg[4]) != 8:
 					log.Warn("zmq3<PubSub>: Message dropped: invalid event sequence number")
 					return
+				case len(msg) == 8 && len(msg[7]) != 1:
+					log.Warn("zmq3<PubSub>: Message dropped: invalid flags frame")
+					return
 				}
 
 				log.Debug("zmq3<PubSub>: EVENT message received")
 
+				event, err := newEvent(msg, t.maxDecompressedSize)
+				if err != nil {
+					log.Warnf("zmq3<PubSub>: Message dropped: %v", err)
+					return
+				}
+
 				// Forward the event to the next layer.
-				t.eventCh <- newEvent(msg)
+				t.eventCh <- event
 			},
 		},
 	}
@@ -345,18 +423,53 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 				cmd.errCh <- err
 				return
 			}
-			// Publish the event by sending a message to the broker.
-			if _, err = dealer.SendMessage([][]byte{
+			body, compressed, err := codecs.Compress(buf.Bytes(), t.compressionThreshold)
+			if err != nil {
+				cmd.errCh <- err
+				return
+			}
+			// Ask the broker for the sequence number if requested.
+			token := frameEmpty
+			if args.acked {
+				t.lastToken++
+				token = make([]byte, 8)
+				binary.BigEndian.PutUint64(token, t.lastToken)
+			}
+			msg := [][]byte{
 				[]byte(args.eventKind),
 				frameHeader,
 				frameEventType,
-				frameEmpty,
-				buf.Bytes(),
-			}); err != nil {
+				token,
+				body,
+			}
+			if args.props != nil || compressed {
+				props := frameEmpty
+				if args.props != nil {
+					if props, err = encodeEventProps(args.props); err != nil {
+						cmd.errCh <- err
+						return
+					}
+				}
+				msg = append(msg, props)
+			}
+			// The flags frame must follow the properties frame, empty or not.
+			if compressed {
+				msg = append(msg, frameEventCompressed)
+			}
+			// Publish the event by sending a message to the broker.
+			if _, err = dealer.SendMessage(msg); err != nil {
 				cmd.errCh <- err
 				t.abort(err)
 				return
 			}
+			if args.acked {
+				t.expectAck(t.lastToken, &pubsub.PublishedEvent{
+					Kind:      args.eventKind,
+					Publisher: t.identity,
+					Props:     args.props,
+					Body:      buf.Bytes(),
+				})
+			}
 			cmd.errCh <- nil
 		},
 		cmdSubscribe: func(c loop.Cmd) {
@@ -441,6 +554,55 @@ func (t *Transport) loop(dealer *zmq.Socket, sub *zmq.Socket) {
 	}
 }
 
+// expectAck remembers event until the broker acknowledges it.
+func (t *Transport) expectAck(token uint64, event *pubsub.PublishedEvent) {
+	if len(t.pendingAcks) == maxPendingAcks {
+		t.pendingAcks[0] = nil
+		t.pendingAcks = t.pendingAcks[1:]
+	}
+	t.pendingAcks = append(t.pendingAcks, &pendingAck{token, event})
+}
+
+// handleAck forwards the event acknowledged by msg to the next layer.
+func (t *Transport) handleAck(msg [][]byte) {
+	// FRAME 2: event kind (string)
+	// FRAME 3: acknowledgement token (uint64, BE)
+	// FRAME 4: event sequence number (uint32 or uint64, BE)
+	if len(msg) != 5 || len(msg[3]) != 8 {
+		log.Warn("zmq3<PubSub>: Invalid ACK message")
+		return
+	}
+	seq, err := decodeSeq(msg[4])
+	if err != nil {
+		log.Warn("zmq3<PubSub>: Invalid event sequence number")
+		return
+	}
+
+	log.Debug("zmq3<PubSub>: ACK message received")
+
+	// The acknowledgements arrive in order, so the events published before
+	// the one acknowledged are not going to be acknowledged any more.
+	token := binary.BigEndian.Uint64(msg[3])
+	for len(t.pendingAcks) != 0 {
+		pending := t.pendingAcks[0]
+		if pending.token > token {
+			return
+		}
+		t.pendingAcks[0] = nil
+		t.pendingAcks = t.pendingAcks[1:]
+
+		if pending.token == token {
+			if pending.event.Kind != string(msg[2]) {
+				log.Warn("zmq3<PubSub>: ACK event kind mismatch")
+				return
+			}
+			pending.event.Seq = seq
+			t.ackCh <- pending.event
+			return
+		}
+	}
+}
+
 func (t *Transport) abort(err error) {
 	// Make sure we don't send to t.abortCh twice.
 	select {
@@ -92,7 +92,11 @@ func (req *remoteRequest) Method() string {
 }
 
 func (req *remoteRequest) UnmarshalArgs(dst interface{}) error {
-	return codecs.MessagePack.Decode(bytes.NewReader(req.msg[5]), dst)
+	args, err := req.t.unpack(req.msg, 5, 8)
+	if err != nil {
+		return err
+	}
+	return codecs.MessagePack.Decode(bytes.NewReader(args), dst)
 }
 
 type signalProgressCmd struct {
@@ -152,17 +156,26 @@ func (req *remoteRequest) Resolve(returnCode rpc.ReturnCode, returnValue interfa
 	if err := codecs.MessagePack.Encode(&valueBuffer, returnValue); err != nil {
 		return err
 	}
+	value, compressed, err := codecs.Compress(valueBuffer.Bytes(), req.t.compressionThreshold)
+	if err != nil {
+		return err
+	}
+
+	msg := [][]byte{
+		req.msg[0],
+		frameHeader,
+		frameReplyMT,
+		req.msg[3],
+		[]byte{byte(returnCode)},
+		value,
+	}
+	if compressed {
+		msg = append(msg, frameFlagsCompressed)
+	}
 
 	errCh := make(chan error, 1)
 	req.t.exec(&replyCmd{
-		msg: [][]byte{
-			req.msg[0],
-			frameHeader,
-			frameReplyMT,
-			req.msg[3],
-			[]byte{byte(returnCode)},
-			valueBuffer.Bytes(),
-		},
+		msg:   msg,
 		errCh: errCh,
 	})
 	if err := <-errCh; err != nil {
@@ -205,15 +218,25 @@ func (cmd *sendStreamFrameCmd) ErrorChan() chan<- error {
 }
 
 func (w *streamWriter) Write(p []byte) (n int, err error) {
+	payload, compressed, err := codecs.Compress(p, w.transport.compressionThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := [][]byte{
+		w.receiver,
+		frameHeader,
+		frameStreamFrameMT,
+		w.tag,
+		payload,
+	}
+	if compressed {
+		msg = append(msg, frameFlagsCompressed)
+	}
+
 	errCh := make(chan error, 1)
 	w.transport.exec(&sendStreamFrameCmd{
-		msg: [][]byte{
-			w.receiver,
-			frameHeader,
-			frameStreamFrameMT,
-			w.tag,
-			p,
-		},
+		msg:   msg,
 		errCh: errCh,
 	})
 	if err := <-errCh; err != nil {
@@ -226,8 +249,12 @@ func (w *streamWriter) Write(p []byte) (n int, err error) {
 
 type streamFrame [][]byte
 
-func newStreamFrame(msg [][]byte) rpc.StreamFrame {
-	return streamFrame(msg)
+func newStreamFrame(t *Transport, msg [][]byte) (rpc.StreamFrame, error) {
+	payload, err := t.unpack(msg, 4, 5)
+	if err != nil {
+		return nil, err
+	}
+	return streamFrame([][]byte{msg[0], msg[1], msg[2], msg[3], payload}), nil
 }
 
 func (frame streamFrame) TargetStreamTag() rpc.StreamTag {
@@ -246,14 +273,17 @@ func (frame streamFrame) Payload() []byte {
 
 // rpc.RemoteCallReply ---------------------------------------------------------
 
-type remoteCallReply [][]byte
+type remoteCallReply struct {
+	t   *Transport
+	msg [][]byte
+}
 
-func newReply(msg [][]byte) rpc.RemoteCallReply {
-	return remoteCallReply(msg)
+func newReply(t *Transport, msg [][]byte) rpc.RemoteCallReply {
+	return &remoteCallReply{t, msg}
 }
 
-func (reply remoteCallReply) TargetCallId() rpc.RequestID {
-	msg := [][]byte(reply)
+func (reply *remoteCallReply) TargetCallId() rpc.RequestID {
+	msg := reply.msg
 	var id rpc.RequestID
 	if err := binary.Read(bytes.NewReader(msg[3]), binary.BigEndian, &id); err != nil {
 		panic(err)
@@ -261,14 +291,25 @@ func (reply remoteCallReply) TargetCallId() rpc.RequestID {
 	return id
 }
 
-func (reply remoteCallReply) ReturnCode() rpc.ReturnCode {
-	msg := [][]byte(reply)
-	return rpc.ReturnCode(msg[4][0])
+func (reply *remoteCallReply) ReturnCode() rpc.ReturnCode {
+	return rpc.ReturnCode(reply.msg[4][0])
 }
 
-func (reply remoteCallReply) UnmarshalReturnValue(dst interface{}) error {
-	msg := [][]byte(reply)
-	return codecs.MessagePack.Decode(bytes.NewReader(msg[5]), dst)
+func (reply *remoteCallReply) UnmarshalReturnValue(dst interface{}) error {
+	value, err := reply.t.unpack(reply.msg, 5, 6)
+	if err != nil {
+		return err
+	}
+	return codecs.MessagePack.Decode(bytes.NewReader(value), dst)
+}
+
+// unpack returns msg[payload], decompressed in case the optional flags frame
+// msg[flags] is present and has FlagCompressed set.
+func (t *Transport) unpack(msg [][]byte, payload, flags int) ([]byte, error) {
+	if len(msg) <= flags || msg[flags][0]&FlagCompressed == 0 {
+		return msg[payload], nil
+	}
+	return codecs.Decompress(msg[payload], t.maxDecompressedSize)
 }
 
 // Errors ----------------------------------------------------------------------
@@ -35,6 +35,18 @@ type TransportFactory struct {
 	Endpoint string
 	Sndhwm   int
 	Rcvhwm   int
+
+	// Arguments, return values and stream frames of at least this many bytes
+	// are compressed, zero meaning that compression is disabled. Compressed
+	// payloads are decompressed transparently no matter what the threshold is.
+	//
+	// Compressed payloads are signalled by an extra flags frame, so the broker
+	// and the other apps must support it when compression is enabled.
+	CompressionThreshold int
+
+	// Compressed payloads decompressing to more than this many bytes are
+	// rejected, zero meaning codecs.DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
 }
 
 func NewTransportFactory() *TransportFactory {
@@ -82,6 +94,10 @@ type Transport struct {
 	// Error to be returned from Wait
 	err error
 
+	// Compression settings, see TransportFactory.
+	compressionThreshold int
+	maxDecompressedSize  int
+
 	// Output interface for the Service using this Transport
 	requestCh   chan rpc.RemoteRequest
 	progressCh  chan rpc.RequestID
@@ -131,6 +147,9 @@ func (factory *TransportFactory) NewTransport(identity string) (rpc.Transport, e
 		streamingCh:      make(chan rpc.StreamFrame),
 		replyCh:          make(chan rpc.RemoteCallReply),
 		errorCh:          make(chan error),
+
+		compressionThreshold: factory.CompressionThreshold,
+		maxDecompressedSize:  factory.MaxDecompressedSize,
 	}
 
 	go t.loop(dealer)
@@ -241,6 +260,12 @@ const (
 	MessageTypeKthxbye
 )
 
+// Flags that can be sent in the optional last frame of REQUEST, STREAMFRAME
+// and REPLY messages.
+const (
+	FlagCompressed byte = 1 << iota
+)
+
 var (
 	frameEmpty  = []byte{}
 	frameHeader = []byte(Header)
@@ -254,6 +279,8 @@ var (
 	frameReplyMT       = []byte{MessageTypeReply}
 	framePongMT        = []byte{MessageTypePong}
 	frameKthxbyeMT     = []byte{MessageTypeKthxbye}
+
+	frameFlagsCompressed = []byte{FlagCompressed}
 )
 
 var pongMessage = [][]byte{
@@ -296,11 +323,12 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 					// FRAME 0: sender
 					// FRAME 3: request ID (uint16; BE)
 					// FRAME 4: method (string)
-					// FRAME 5: method arguments (object; encoded with MessagePack)
+					// FRAME 5: method arguments (object; encoded with MessagePack; possibly compressed)
 					// FRAME 6: stdout stream tag (empty or uint16; BE)
 					// FRAME 7: stderr stream tag (empty of uint16; BE)
+					// FRAME 8: flags (optional; byte)
 					switch {
-					case len(msg) != 8:
+					case len(msg) != 8 && len(msg) != 9:
 						log.Warn("zmq3<RPC>: REQUEST: invalid message length")
 						return
 					case len(msg[0]) == 0:
@@ -318,6 +346,9 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 					case len(msg[7]) != 0 && len(msg[7]) != 2:
 						log.Warn("zmq3<RPC>: REQUEST: invalid stdout tag frame received")
 						return
+					case len(msg) == 9 && len(msg[8]) != 1:
+						log.Warn("zmq3<RPC>: REQUEST: invalid flags frame received")
+						return
 					}
 
 					req, err := t.newRequest(msg)
@@ -378,8 +409,9 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 					// FRAME 0: empty
 					// FRAME 3: stream tag (uint16; BE)
 					// FRAME 4: frame payload (bytes)
+					// FRAME 5: flags (optional; byte)
 					switch {
-					case len(msg) != 5:
+					case len(msg) != 5 && len(msg) != 6:
 						log.Warn("zmq3<RPC>: STREAMFRAME: invalid message length")
 						return
 					case len(msg[0]) != 0:
@@ -391,17 +423,26 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 					case len(msg[4]) == 0:
 						log.Warn("zmq3<RPC>: STREAMFRAME: empty frame received")
 						return
+					case len(msg) == 6 && len(msg[5]) != 1:
+						log.Warn("zmq3<RPC>: STREAMFRAME: invalid flags frame received")
+						return
 					}
 
-					t.streamingCh <- newStreamFrame(msg)
+					frame, err := newStreamFrame(t, msg)
+					if err != nil {
+						log.Warnf("zmq3<RPC>: STREAMFRAME: %v", err)
+						return
+					}
+					t.streamingCh <- frame
 
 				case MessageTypeReply:
 					// FRAME 0: empty
 					// FRAME 3: request ID (uint16; BE)
 					// FRAME 4: return code (byte)
-					// FRAME 5: return value (object; encoded with MessagePack)
+					// FRAME 5: return value (object; encoded with MessagePack; possibly compressed)
+					// FRAME 6: flags (optional; byte)
 					switch {
-					case len(msg) != 6:
+					case len(msg) != 6 && len(msg) != 7:
 						log.Warn("zmq3<RPC>: REPLY: invalid message length")
 						return
 					case len(msg[0]) != 0:
@@ -413,9 +454,12 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 					case len(msg[4]) != 1:
 						log.Warn("zmq3<RPC>: REPLY: invalid return code frame received")
 						return
+					case len(msg) == 7 && len(msg[6]) != 1:
+						log.Warn("zmq3<RPC>: REPLY: invalid flags frame received")
+						return
 					}
 
-					t.replyCh <- newReply(msg)
+					t.replyCh <- newReply(t, msg)
 
 				case MessageTypePing:
 					// FRAME 0: empty
@@ -480,6 +524,11 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 				cmd.ErrorChan() <- err
 				return
 			}
+			args, compressed, err := codecs.Compress(argsBuffer.Bytes(), t.compressionThreshold)
+			if err != nil {
+				cmd.ErrorChan() <- err
+				return
+			}
 
 			// Marshal stdout tag.
 			var stdoutTagBuffer bytes.Buffer
@@ -494,16 +543,20 @@ func (t *Transport) loop(dealer *zmq.Socket) {
 			}
 
 			// Send the request to the broker.
-			if _, err := dealer.SendMessage([][]byte{
+			msg := [][]byte{
 				frameEmpty,
 				frameHeader,
 				frameRequestMT,
 				idBuffer.Bytes(),
 				[]byte(cmd.Method()),
-				argsBuffer.Bytes(),
+				args,
 				stdoutTagBuffer.Bytes(),
 				stderrTagBuffer.Bytes(),
-			}); err != nil {
+			}
+			if compressed {
+				msg = append(msg, frameFlagsCompressed)
+			}
+			if _, err := dealer.SendMessage(msg); err != nil {
 				cmd.ErrorChan() <- err
 				t.abort(err)
 				return
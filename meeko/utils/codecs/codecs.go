@@ -22,14 +22,30 @@ var msgpackHandle = &codec.MsgpackHandle{
 	RawToString: true,
 }
 
-type msgpackCodec struct{}
+// msgpackCanonicalHandle sorts the map keys, so that equal objects are always
+// encoded into the same bytes.
+var msgpackCanonicalHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{
+		RawToString: true,
+	}
+	h.Canonical = true
+	return h
+}()
+
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
 
 func (c *msgpackCodec) Encode(w io.Writer, src interface{}) error {
-	return codec.NewEncoder(w, msgpackHandle).Encode(src)
+	return codec.NewEncoder(w, c.handle).Encode(src)
 }
 
 func (c *msgpackCodec) Decode(r io.Reader, dst interface{}) error {
-	return codec.NewDecoder(r, msgpackHandle).Decode(dst)
+	return codec.NewDecoder(r, c.handle).Decode(dst)
 }
 
-var MessagePack Codec = &msgpackCodec{}
+var MessagePack Codec = &msgpackCodec{msgpackHandle}
+
+// MessagePackCanonical is MessagePack encoding the maps with their keys sorted.
+// It is slower, but the output can be compared or used as a map key.
+var MessagePackCanonical Codec = &msgpackCodec{msgpackCanonicalHandle}
@@ -0,0 +1,75 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package codecs
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// DefaultMaxDecompressedSize is the maximum size of a decompressed payload
+// used by Decompress unless specified otherwise.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// Compress compresses payload using DEFLATE in case it is at least threshold
+// bytes long, threshold being zero or negative meaning never. The payload is
+// returned unchanged when it is not compressed, which is also the case when
+// compression would not make it any smaller. compressed is true otherwise.
+//
+// The compressed payloads cannot be told from the plain ones, so the sender
+// must signal compression out of band, e.g. using a flags frame. Decompress
+// must be used on the receiving side.
+func Compress(payload []byte, threshold int) (out []byte, compressed bool, err error) {
+	if threshold <= 0 || len(payload) < threshold {
+		return payload, false, nil
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+
+	if buf.Len() >= len(payload) {
+		return payload, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// Decompress reverses Compress. ErrPayloadTooLarge is returned in case
+// the payload decompresses to more than max bytes, max being zero or negative
+// meaning DefaultMaxDecompressedSize.
+func Decompress(payload []byte, max int) ([]byte, error) {
+	if max <= 0 {
+		max = DefaultMaxDecompressedSize
+	}
+
+	r := flate.NewReader(bytes.NewReader(payload))
+	defer r.Close()
+
+	// Read one extra byte to tell whether the limit was exceeded.
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrPayloadTooLarge
+	}
+	return out, nil
+}
+
+// Errors ----------------------------------------------------------------------
+
+var ErrPayloadTooLarge = errors.New("decompressed payload too large")
@@ -0,0 +1,66 @@
+// Copyright (c) 2013 The go-meeko AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package codecs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	payload := bytes.Repeat([]byte("meeko"), 100)
+
+	cases := []struct {
+		name       string
+		threshold  int
+		max        int
+		compressed bool
+		err        error
+	}{
+		{"disabled", 0, 0, false, nil},
+		{"below the threshold", len(payload) + 1, 0, false, nil},
+		{"at the threshold", len(payload), 0, true, nil},
+		{"at the limit", 1, len(payload), true, nil},
+		{"over the limit", 1, len(payload) - 1, true, ErrPayloadTooLarge},
+	}
+
+	for _, c := range cases {
+		out, compressed, err := Compress(payload, c.threshold)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if compressed != c.compressed {
+			t.Errorf("%v: expected compressed %v, got %v", c.name, c.compressed, compressed)
+			continue
+		}
+		if !compressed {
+			if !bytes.Equal(out, payload) {
+				t.Errorf("%v: expected the payload to be returned unchanged", c.name)
+			}
+			continue
+		}
+
+		decompressed, err := Decompress(out, c.max)
+		if err != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(decompressed, payload) {
+			t.Errorf("%v: the decompressed payload does not match", c.name)
+		}
+	}
+}
+
+func TestCompress_Incompressible(t *testing.T) {
+	payload := []byte{0x93, 0x01, 0x02, 0x03}
+	out, compressed, err := Compress(payload, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed || !bytes.Equal(out, payload) {
+		t.Error("expected the payload to be returned unchanged")
+	}
+}